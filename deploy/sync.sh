@@ -7,5 +7,11 @@ selected="$(find "${DIVELOG_LOCAL_BACKUP_DIR}" -maxdepth 1 -type f -name "${pref
 selected_path="$(realpath "${selected}")"
 selected_file="$(basename "${selected}")"
 
+checksum="$(mktemp)"
+trap 'rm -f "${checksum}"' EXIT
+(cd "$(dirname -- "${selected_path}")" && sha256sum "${selected_file}") > "${checksum}"
+
+# the checksum file is sent first, so that the server never builds a partially sent data file
 echo "Sending ${selected_path} to the remote target ${DIVELOG_SSH_LOGIN_TARGET} ..."
+rsync -vc "${checksum}" "${DIVELOG_SSH_LOGIN_TARGET}:${DIVELOG_HOST_STORE_DIR}/${selected_file}.sha256"
 rsync -vc "${selected_path}" "${DIVELOG_SSH_LOGIN_TARGET}:${DIVELOG_HOST_STORE_DIR}/${selected_file}"
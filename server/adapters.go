@@ -1,6 +1,10 @@
 package server
 
-import "net/http"
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
 
 // Adapter is an HTTP(S) handler that invokes another HTTP(S) handler.
 type Adapter func(h http.Handler) http.Handler
@@ -22,6 +26,22 @@ func StripPrefix(prefix string) Adapter {
 	}
 }
 
+// RequireToken returns an adapter that rejects requests without
+// the given bearer token in the Authorization header.
+func RequireToken(token string) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="bluefin"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
 func funcWithDataAccess(fn func(http.ResponseWriter, *http.Request, *DiveLog)) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		fn(w, r, acquireDataAccess())
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// Admin API; registered only if an admin token is configured, in any mode.
+
+func fetchSnapshots(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
+	history, rolledBack := snapshotHistory()
+	sendSnapshotHistory(w, NewSnapshotHistory(history, divelog, rolledBack))
+}
+
+// rollbackToSnapshot serves a previous snapshot until a newer data file is built,
+// and responds with the snapshot history.
+func rollbackToSnapshot(w http.ResponseWriter, r *http.Request) {
+	generation, err := strconv.ParseUint(r.PathValue("generation"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid snapshot generation", http.StatusBadRequest)
+		return
+	}
+
+	divelog, err := rollbackSnapshot(generation)
+	if errors.Is(err, errSnapshotNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	history, rolledBack := snapshotHistory()
+	sendSnapshotHistory(w, NewSnapshotHistory(history, divelog, rolledBack))
+}
+
+func sendSnapshotHistory(w http.ResponseWriter, history *SnapshotHistory) {
+	encoded, err := json.Marshal(history)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	send(w, encoded)
+}
+
+func fetchLogSettings(w http.ResponseWriter, r *http.Request) {
+	sendLogSettings(w, currentLogSettings())
+}
+
+// updateLogSettings changes the logging settings given in the request body, and keeps the others,
+// until the server is restarted. It responds with the settings in use.
+func updateLogSettings(w http.ResponseWriter, r *http.Request) {
+	settings := currentLogSettings()
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&settings); err != nil {
+		http.Error(w, "invalid logging settings: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if problems := settings.validate(); len(problems) > 0 {
+		http.Error(w, errors.Join(problems...).Error(), http.StatusBadRequest)
+		return
+	}
+	if err := configureLogging(settings); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	traceContext(r.Context(), _control, "logging settings changed: level %s, format %s, subsystems %s, file %q",
+		settings.Level, settings.Format, settings.Subsystems, settings.File)
+	sendLogSettings(w, settings)
+}
+
+func sendLogSettings(w http.ResponseWriter, settings LogSettings) {
+	encoded, err := json.Marshal(settings)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	send(w, encoded)
+}
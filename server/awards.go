@@ -0,0 +1,121 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"src.acicovic.me/divelog/server/utils"
+)
+
+// Milestone dive counts for which awards are derived automatically.
+var _award_milestones = []int{1, 50, 100, 200, 300, 500, 1000}
+
+// firstDiveRule awards the chronologically first dive that matches the rule.
+type firstDiveRule struct {
+	key   string
+	match func(dive *Dive, site *DiveSite) bool
+}
+
+var _first_dive_rules = []firstDiveRule{
+	{"1st-seawater-dive", func(d *Dive, _ *DiveSite) bool { return d.Salinity == "salt water" }},
+	{"1st-night-dive", func(d *Dive, _ *DiveSite) bool { return d.IsNightDive() }},
+	{"1st-nitrox-dive", func(d *Dive, _ *DiveSite) bool { return strings.HasPrefix(d.Gas, "nitrox") }},
+	{"1st-30m-dive", func(d *Dive, _ *DiveSite) bool { return d.depthMax >= 30 }},
+	{"1st-40m-dive", func(d *Dive, _ *DiveSite) bool { return d.depthMax >= 40 }},
+}
+
+// DetectAwards derives milestone awards from the dive log (if automatic is set) and merges
+// them with awards from manual _award_{value} tags. A manual tag takes precedence over the
+// automatically detected award with the same key, wherever in the log the tag is placed.
+func DetectAwards(divelog *DiveLog, automatic bool) {
+	manual := make(map[string]bool)
+	for _, dive := range divelog.Dives[1:] {
+		for _, key := range dive.manualAwards {
+			manual[key] = true
+		}
+	}
+
+	if automatic {
+		detectMilestones(divelog, manual)
+	}
+
+	for _, dive := range divelog.Dives[1:] {
+		titles := make([]string, 0, len(dive.manualAwards)+len(dive.certs)+len(dive.autoAwards))
+		for _, key := range dive.manualAwards {
+			titles = append(titles, divelog.mappings.Awards[key])
+		}
+		for _, cert := range dive.certs {
+			titles = append(titles, divelog.trainingCatalogue.certificationTitle(cert.agency, cert.level)+" certification!")
+		}
+		for _, key := range dive.autoAwards {
+			titles = append(titles, awardTitle(key, divelog))
+		}
+		dive.Award = strings.Join(titles, " ")
+	}
+}
+
+func detectMilestones(divelog *DiveLog, manual map[string]bool) {
+	dives := slices.Clone(divelog.Dives[1:])
+	sort.SliceStable(dives, func(i, j int) bool {
+		return dives[i].datetime.Before(dives[j].datetime)
+	})
+
+	var (
+		awarded = make(map[string]bool)
+		earn    = func(dive *Dive, key string) {
+			if !manual[key] && !awarded[key] {
+				awarded[key] = true
+				dive.autoAwards = append(dive.autoAwards, key)
+			}
+		}
+	)
+	for position, dive := range dives {
+		site := divelog.DiveSites[dive.DiveSiteID]
+
+		// logs may start in the middle of a diving career, so the dive
+		// number is preferred over the position of the dive in the log
+		count := dive.Number
+		if count == 0 {
+			count = position + 1
+		}
+		if slices.Contains(_award_milestones, count) {
+			earn(dive, milestoneAwardKey(count))
+		}
+
+		for _, rule := range _first_dive_rules {
+			if rule.match(dive, site) {
+				earn(dive, rule.key)
+			}
+		}
+
+		if site.Region != UnlabeledRegion {
+			earn(dive, "1st-dive-in-"+utils.Slug(site.Region))
+		}
+	}
+}
+
+func milestoneAwardKey(count int) string {
+	if count == 1 {
+		return "1st-dive"
+	}
+	return fmt.Sprintf("%dth-dive", count)
+}
+
+func awardTitle(key string, divelog *DiveLog) string {
+	if title, ok := divelog.mappings.Awards[key]; ok {
+		return title
+	}
+	if slug, ok := strings.CutPrefix(key, "1st-dive-in-"); ok {
+		for _, site := range divelog.DiveSites[1:] {
+			if utils.Slug(site.Region) == slug {
+				return fmt.Sprintf("First dive in %s!", site.Region)
+			}
+		}
+	}
+	if count, ok := strings.CutSuffix(key, "th-dive"); ok {
+		return count + "th dive!"
+	}
+	return key
+}
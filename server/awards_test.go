@@ -0,0 +1,100 @@
+package server
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// awardTestLog returns a dive log with dives a day apart at a site in the region,
+// numbered from 1 in the order listed.
+func awardTestLog(region string, dives ...*Dive) *DiveLog {
+	divelog := &DiveLog{
+		DiveSites: []*DiveSite{nil, {ID: 1, Name: "Vis", Region: region}},
+		Dives:     []*Dive{nil},
+		mappings:  _default_mappings,
+	}
+	day := time.Date(2023, time.January, 1, 10, 0, 0, 0, time.UTC)
+	for i, dive := range dives {
+		dive.ID, dive.Number, dive.DiveSiteID = i+1, i+1, 1
+		if dive.datetime.IsZero() {
+			dive.datetime = day.AddDate(0, 0, i)
+		}
+		divelog.Dives = append(divelog.Dives, dive)
+	}
+	return divelog
+}
+
+func TestDetectAwards(t *testing.T) {
+	tests := []struct {
+		name      string
+		region    string
+		dives     []*Dive
+		automatic bool
+		// award keys by dive number
+		want map[int][]string
+	}{
+		{
+			name:      "automatic",
+			region:    UnlabeledRegion,
+			dives:     []*Dive{{}, {Salinity: "salt water"}, {Salinity: "salt water", depthMax: 32}},
+			automatic: true,
+			want:      map[int][]string{1: {"1st-dive"}, 2: {"1st-seawater-dive"}, 3: {"1st-30m-dive"}},
+		},
+		{
+			name:      "manual tag wins over the automatic award",
+			region:    UnlabeledRegion,
+			dives:     []*Dive{{}, {Salinity: "salt water"}, {Salinity: "salt water", manualAwards: []string{"1st-seawater-dive"}}},
+			automatic: true,
+			want:      map[int][]string{1: {"1st-dive"}, 3: {"1st-seawater-dive"}},
+		},
+		{
+			name:      "manual tag on an earlier dive",
+			region:    UnlabeledRegion,
+			dives:     []*Dive{{manualAwards: []string{"1st-dive", "1st-seawater-dive"}}, {Salinity: "salt water"}},
+			automatic: true,
+			want:      map[int][]string{1: {"1st-dive", "1st-seawater-dive"}},
+		},
+		{
+			name:      "chronological, not file order",
+			region:    UnlabeledRegion,
+			dives:     []*Dive{{}, {depthMax: 31}, {depthMax: 35, datetime: time.Date(2022, time.June, 1, 10, 0, 0, 0, time.UTC)}},
+			automatic: true,
+			want:      map[int][]string{1: {"1st-dive"}, 3: {"1st-30m-dive"}},
+		},
+		{
+			name:      "region",
+			region:    "Adriatic Sea",
+			dives:     []*Dive{{}, {}},
+			automatic: true,
+			want:      map[int][]string{1: {"1st-dive", "1st-dive-in-adriatic-sea"}},
+		},
+		{
+			name:      "manual only",
+			region:    UnlabeledRegion,
+			dives:     []*Dive{{Salinity: "salt water"}, {manualAwards: []string{"100th-dive"}}},
+			automatic: false,
+			want:      map[int][]string{2: {"100th-dive"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			divelog := awardTestLog(tt.region, tt.dives...)
+			DetectAwards(divelog, tt.automatic)
+			for _, dive := range divelog.Dives[1:] {
+				got := append(slices.Clone(dive.manualAwards), dive.autoAwards...)
+				if want := tt.want[dive.Number]; !slices.Equal(got, want) {
+					t.Errorf("awards of dive %d = %q, want %q", dive.Number, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDetectAwardsTitles(t *testing.T) {
+	divelog := awardTestLog("Adriatic Sea", &Dive{manualAwards: []string{"1st-wreck-dive"}})
+	DetectAwards(divelog, true)
+	if got, want := divelog.Dives[1].Award, "First wreck dive! First dive! First dive in Adriatic Sea!"; got != want {
+		t.Errorf("award = %q, want %q", got, want)
+	}
+}
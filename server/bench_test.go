@@ -0,0 +1,222 @@
+package server
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"src.acicovic.me/divelog/subsurface"
+)
+
+// The benchmarks time page and data handlers on a synthetic dive log built with the builder
+// code, and report the time per dive. Dives are indexed by trip, site, tag, buddy and year once
+// per build, so the time per dive of handlers which list all dives should stay about the same
+// as the dive log grows. Run them with go test -bench . ./server
+
+const benchDives = 10000
+
+var _bench_tags = []string{"reef", "wreck", "night", "drift", "cave", "shark", "training", "macro"}
+
+var _bench_buddies = []string{"Ana", "Marko", "Ivana", "Luka", "Petra", "Tomislav", "Maja", "Josip", "Nina", "Filip"}
+
+var _bench = struct {
+	once    sync.Once
+	divelog *DiveLog
+	pages   bool
+}{}
+
+// benchDiveLog returns the synthetic dive log, and loads the page template if pages are rendered.
+func benchDiveLog(b *testing.B, pages bool) *DiveLog {
+	b.Helper()
+	_bench.once.Do(func() {
+		// tests run in the package directory, the template is found from the repository root
+		if tmpl, err := template.ParseFiles(filepath.Join("..", FilePageTemplate)); err == nil {
+			_page_template = tmpl
+			_bench.pages = true
+		}
+		_bench.divelog = syntheticDiveLog(benchDives)
+	})
+	if pages && !_bench.pages {
+		b.Skipf("%s not found", FilePageTemplate)
+	}
+	return _bench.divelog
+}
+
+// benchmarkRoute times the handler serving the path, which is matched against the pattern.
+func benchmarkRoute(b *testing.B, pattern string, path string, handler func(http.ResponseWriter, *http.Request, *DiveLog), page bool) {
+	divelog := benchDiveLog(b, page)
+	if path == "" {
+		path = pattern
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		b.StopTimer()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		setPathValues(r, pattern)
+		w := &benchResponseWriter{header: make(http.Header), status: http.StatusOK}
+		b.StartTimer()
+
+		handler(w, r, divelog)
+
+		if w.status != http.StatusOK {
+			b.Fatalf("%s: status %d", path, w.status)
+		}
+	}
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*benchDives), "ns/dive")
+}
+
+func BenchmarkBuildDiveLog(b *testing.B) {
+	b.ReportAllocs()
+	for range b.N {
+		syntheticDiveLog(benchDives)
+	}
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*benchDives), "ns/dive")
+}
+
+func BenchmarkFetchDivesHeadOnly(b *testing.B) {
+	benchmarkRoute(b, "/data/dives?headonly=true", "", fetchDives, false)
+}
+
+func BenchmarkFetchDives(b *testing.B) {
+	benchmarkRoute(b, "/data/dives", "", fetchDives, false)
+}
+
+func BenchmarkFetchTaggedDives(b *testing.B) {
+	benchmarkRoute(b, "/data/dives?tag=wreck", "", fetchDives, false)
+}
+
+func BenchmarkFetchSites(b *testing.B) {
+	benchmarkRoute(b, "/data/sites", "", fetchSites, false)
+}
+
+func BenchmarkFetchTrips(b *testing.B) {
+	benchmarkRoute(b, "/data/trips", "", fetchTrips, false)
+}
+
+func BenchmarkFetchTags(b *testing.B) {
+	benchmarkRoute(b, "/data/tags", "", fetchTags, false)
+}
+
+func BenchmarkFetchBuddies(b *testing.B) {
+	benchmarkRoute(b, "/data/buddies", "", fetchBuddies, false)
+}
+
+func BenchmarkFetchGear(b *testing.B) {
+	benchmarkRoute(b, "/data/gear", "", fetchGear, false)
+}
+
+func BenchmarkRenderDives(b *testing.B) {
+	benchmarkRoute(b, "/hms/dives", "", renderDives, true)
+}
+
+func BenchmarkRenderTrips(b *testing.B) {
+	benchmarkRoute(b, "/hms/trips", "", renderTrips, true)
+}
+
+func BenchmarkRenderSites(b *testing.B) {
+	benchmarkRoute(b, "/hms/sites", "", renderSites, true)
+}
+
+func BenchmarkRenderSite(b *testing.B) {
+	benchmarkRoute(b, "/hms/sites/{id}", "/hms/sites/"+benchDiveLog(b, true).DiveSites[1].StableID, renderSite, true)
+}
+
+func BenchmarkRenderTrip(b *testing.B) {
+	benchmarkRoute(b, "/hms/trips/{id}", "/hms/trips/"+benchDiveLog(b, true).DiveTrips[1].StableID, renderTrip, true)
+}
+
+func BenchmarkRenderTags(b *testing.B) {
+	benchmarkRoute(b, "/hms/tags", "", renderTags, true)
+}
+
+func BenchmarkRenderTaggedDives(b *testing.B) {
+	benchmarkRoute(b, "/hms/tags/{tag}", "/hms/tags/wreck", renderTaggedDives, true)
+}
+
+func BenchmarkRenderBuddies(b *testing.B) {
+	benchmarkRoute(b, "/hms/buddies", "", renderBuddies, true)
+}
+
+// setPathValues sets the path values of the request as the multiplexer would for the pattern.
+func setPathValues(r *http.Request, pattern string) {
+	segments := strings.Split(r.URL.Path, "/")
+	for i, wildcard := range strings.Split(pattern, "/") {
+		if name, ok := strings.CutPrefix(wildcard, "{"); ok && i < len(segments) {
+			r.SetPathValue(strings.TrimSuffix(name, "}"), segments[i])
+		}
+	}
+}
+
+// benchResponseWriter discards the response body, so that only the handler is timed.
+type benchResponseWriter struct {
+	header http.Header
+	status int
+}
+
+func (w *benchResponseWriter) Header() http.Header { return w.header }
+
+func (w *benchResponseWriter) Write(b []byte) (int, error) { return io.Discard.Write(b) }
+
+func (w *benchResponseWriter) WriteHeader(status int) { w.status = status }
+
+// syntheticDiveLog builds a dive log of daily dives on 20 dives per site and 10 dives per trip,
+// with the same builder callbacks which the decoder calls for a data file.
+func syntheticDiveLog(dives int) *DiveLog {
+	newDiveLog("synthetic-"+strconv.Itoa(dives), time.Now().UTC())
+	_divelog.mappings = _default_mappings
+
+	h := &SubsurfaceCallbackHandler{}
+	h.HandleBegin()
+	h.HandleHeader("bluefin", "bench")
+
+	sites := max(dives/20, 1)
+	for i := 1; i <= sites; i++ {
+		h.HandleDiveSite(fmt.Sprintf("%08x", i), fmt.Sprintf("Site %d, Island %d", i, i%50),
+			fmt.Sprintf("%.4f %.4f", float64(i%180)-90, float64(i%360)-180), "")
+	}
+	trips := max(dives/10, 1)
+	for i := 1; i <= trips; i++ {
+		h.HandleDiveTrip(fmt.Sprintf("Trip %d", i))
+	}
+
+	first := time.Date(1990, time.January, 1, 10, 0, 0, 0, time.UTC)
+	for i := 1; i <= dives; i++ {
+		h.HandleDive(subsurface.DiveDataHolder{
+			DiveNumber:            i,
+			DiveTripID:            min((i-1)/10+1, trips),
+			DiveSiteUUID:          fmt.Sprintf("%08x", (i*7)%sites+1),
+			Rating:                i%5 + 1,
+			Visibility:            (i+2)%5 + 1,
+			Tags:                  []string{_bench_tags[i%len(_bench_tags)], _bench_tags[(i/3)%len(_bench_tags)]},
+			WaterSalinity:         "1030 g/l",
+			DateTime:              first.Add(time.Duration(i-1) * 24 * time.Hour),
+			Duration:              fmt.Sprintf("%d:00 min", 30+i%40),
+			DiveMasterOrOperator:  fmt.Sprintf("Operator %d", i%25),
+			Buddy:                 _bench_buddies[i%len(_bench_buddies)] + ", " + _bench_buddies[(i/7)%len(_bench_buddies)],
+			Notes:                 "Synthetic dive.",
+			Suit:                  fmt.Sprintf("Suit %d", i%3),
+			CylinderSize:          "12.0 l",
+			CylinderDescription:   "AL100",
+			CylinderStartPressure: "200.0 bar",
+			CylinderEndPressure:   "50.0 bar",
+			WeightType:            "belt",
+			DiveComputerModel:     fmt.Sprintf("Computer %d", i%2),
+			DiveComputerDiveID:    fmt.Sprintf("%08x", i),
+			DepthMax:              fmt.Sprintf("%d.0 m", 10+i%30),
+			DepthMean:             fmt.Sprintf("%d.0 m", 5+i%15),
+			TemperatureWaterMin:   "20.0 C",
+		})
+	}
+	h.HandleEnd()
+	return _divelog
+}
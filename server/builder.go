@@ -1,12 +1,16 @@
 package server
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unicode"
 
@@ -18,83 +22,252 @@ import (
 // so there is no need to guard it (to keep things simple for now).
 var _divelog *DiveLog
 
-func runAndWaitForBuilder() {
-	errChannel := make(chan error)
+// The first build may wait for the newest data file to settle, on top of building it.
+const firstBuildTimeout = 30*time.Second + dataFileSettleChecks*dataFileSettleWindow
+
+// runAndWaitForBuilder waits for the first build, unless the snapshot of
+// a data file could be loaded from the cache, which is served at once.
+func runAndWaitForBuilder() error {
+	errChannel := make(chan error, 1)
+	loadRollbackState()
+	fromCache := loadCachedSnapshot()
+	loadChangeLog(fromCache)
 	go builder(errChannel)
 
+	if fromCache {
+		trace(_control, "serving the cached snapshot, the builder continues in the background")
+		return nil
+	}
+
 	select {
 	case err := <-errChannel:
 		if err != nil {
-			panic(err)
+			return err
 		}
-	case <-time.After(30 * time.Second):
-		panic(errors.New("database initialization timed out"))
+	case <-time.After(firstBuildTimeout):
+		return fmt.Errorf("timed out after %s", firstBuildTimeout)
 	}
 
 	trace(_control, "mandatory database initialization on boot completed")
+	return nil
 }
 
 // Run in a goroutine.
 // For simplicity, the goroutine will not be gracefully stopped,
 // it will be force-stopped once the whole process is killed.
 func builder(firstRun chan error) {
-	var once sync.Once
+	var (
+		once    sync.Once
+		wakeups = watchDataFiles()
+		force   bool
+		done    chan struct{}
+	)
 
 	for {
-		err := buildFromLatestDataFile()
+		attempt := time.Now().UTC()
+		rejected, err := buildFromLatestDataFile(force)
+		_last_build_attempt.Store(&buildAttempt{time: attempt, err: err, rejected: rejected})
+		if done != nil {
+			close(done)
+		}
 
 		once.Do(func() {
 			firstRun <- err
 		})
 
 		if err != nil {
+			observeBuildFailure()
 			trace(_error, "database build failed: %v", err)
 		}
 
-		time.Sleep(time.Minute)
+		select {
+		case <-wakeups:
+			force, done = false, nil
+		case <-_rebuild_requests.signal:
+			force, done = true, takeRebuildRequest()
+		}
 	}
 }
 
-func buildFromLatestDataFile() error {
-	filePath, modTime, err := findLatestDataFile()
+// buildAttempt is the outcome of a builder iteration.
+type buildAttempt struct {
+	time     time.Time
+	err      error
+	rejected []*rejectedDataFile
+}
+
+var _last_build_attempt atomic.Pointer[buildAttempt]
+
+var _rebuild_requests = struct {
+	sync.Mutex
+	signal chan struct{}
+	done   chan struct{}
+}{
+	signal: make(chan struct{}, 1),
+}
+
+// requestRebuild asks the builder for a rebuild, even if there are no newer data files.
+// Requests made before the builder starts the rebuild are coalesced into one rebuild.
+// The returned channel is closed once the rebuild is completed.
+func requestRebuild() <-chan struct{} {
+	r := &_rebuild_requests
+	r.Lock()
+	defer r.Unlock()
+	if r.done == nil {
+		r.done = make(chan struct{})
+		wakeup(r.signal)
+	}
+	return r.done
+}
+
+func takeRebuildRequest() chan struct{} {
+	r := &_rebuild_requests
+	r.Lock()
+	defer r.Unlock()
+	done := r.done
+	r.done = nil
+	return done
+}
+
+// buildFromLatestDataFile builds the newest data file which can be built, trying data files from
+// the newest to the oldest, until reaching the source file of the newest snapshot. Data files
+// which could not be built are returned, even if an older data file was built instead.
+func buildFromLatestDataFile(force bool) ([]*rejectedDataFile, error) {
+	candidates, err := findDataFiles()
 	if err != nil {
-		return err
+		return nil, err
 	}
+	pruneKnownBadDataFiles(candidates)
+	if rb := pendingRollback(); rb != nil {
+		restorePendingRollback(rb, candidates)
+	}
+
+	// changed mappings affect the result of the build as much as a newer data file,
+	// and a changed gear configuration or training catalogue needs a new snapshot to be served
+	mappingsChanged := reloadMappings()
+	gearConfigChanged := reloadGearConfig()
+	trainingCatalogueChanged := reloadTrainingCatalogue()
+
+	var (
+		latestBuild = newestSnapshot()
+		rejected    []*rejectedDataFile
+		problems    []error
+	)
+	for i, candidate := range candidates {
+		if latestBuild != nil && !candidate.modTime.After(latestBuild.Metadata.modTime) && !mappingsChanged && !gearConfigChanged && !trainingCatalogueChanged && !force {
+			break
+		}
 
-	latestBuild := acquireDataAccess()
-	if latestBuild == nil || modTime.After(latestBuild.Metadata.modTime) {
-		_divelog = &DiveLog{}
-		_divelog.Metadata.Source = filePath
-		_divelog.Metadata.modTime = modTime
-		_divelog.Metadata.ModificationTime = modTime.Format(time.RFC3339)
-	} else {
+		// only the newest data file can still be being written, and is waited for
+		settleChecks := 0
+		if i == 0 {
+			settleChecks = dataFileSettleChecks
+		}
+		hash, err := buildFromDataFile(candidate, settleChecks)
+		if err == nil {
+			if len(rejected) > 0 {
+				trace(_error, "fell back to data file %s, as %d newer data files could not be built", candidate.path, len(rejected))
+			}
+			return rejected, nil
+		}
+		trace(_error, "data file %s rejected: %v", candidate.path, err)
+		rejected = append(rejected, &rejectedDataFile{dataFile: candidate, hash: hash, err: err})
+		problems = append(problems, err)
+	}
+
+	if len(rejected) == 0 {
 		trace(_build, "builder found no newer data files, waiting for next iteration...")
-		return nil
+		return nil, nil
 	}
+	return rejected, errors.Join(problems...)
+}
 
-	trace(_build, "database build started, from source file %s", filePath)
-	if err := buildDatabase(); err != nil {
-		return err
+// restorePendingRollback builds the data file of the rollback kept from the previous run,
+// which is served once published, before newer data files are built into the history.
+func restorePendingRollback(rb *rollbackState, candidates []*dataFile) {
+	for _, candidate := range candidates {
+		if candidate.path != rb.Source {
+			continue
+		}
+		if _, err := buildFromDataFile(candidate, 0); err != nil {
+			abandonPendingRollback(fmt.Sprintf("its data file could not be built: %v", err))
+		}
+		break
+	}
+	// no-op if the rollback was restored
+	abandonPendingRollback("its data file is gone or changed")
+}
+
+// buildFromDataFile builds the data file, and publishes the result as the newest snapshot.
+// The content hash of the data file is returned if the data file was read.
+func buildFromDataFile(candidate *dataFile, settleChecks int) (string, error) {
+	if err := waitUntilSettled(candidate.path, settleChecks); err != nil {
+		return "", err
 	}
 
-	swapLatestData(_divelog)
+	start := time.Now()
+	data, err := readDataFile(candidate.path)
+	if err != nil {
+		return "", err
+	}
+	hash := contentHash(data)
+	if bad, known := _known_bad_data_files[hash]; known {
+		trace(_build, "skipping data file %s, its content is known to be invalid", candidate.path)
+		return hash, bad.err
+	}
 
-	trace(_build, "database build completed with modification time %s", modTime)
-	return nil
+	trace(_build, "database build started, from source file %s", candidate.path)
+
+	newDiveLog(candidate.path, candidate.modTime)
+	_divelog.Metadata.sourceHash = hash
+	if err := decodeDatabase(data); err != nil {
+		rememberBadDataFile(candidate.path, hash, err)
+		return hash, err
+	}
+	_divelog.Metadata.builtAt = start.UTC()
+	_divelog.Metadata.buildDuration = time.Since(start)
+	observeBuild(_divelog.Metadata.buildDuration)
+
+	publishSnapshot(_divelog)
+	storeSnapshot(_divelog, snapshotCacheKey(hash, _divelog))
+
+	trace(_build, "database build completed with modification time %s", candidate.modTime)
+	return hash, nil
+}
+
+// newDiveLog prepares _divelog for a build from the source file,
+// with the mappings and configuration files currently in use.
+func newDiveLog(filePath string, modTime time.Time) {
+	_divelog = &DiveLog{}
+	_divelog.mappings = _mappings
+	_divelog.Metadata.Source = filePath
+	_divelog.Metadata.modTime = modTime
+	_divelog.Metadata.ModificationTime = modTime.Format(time.RFC3339)
+
+	// gear configuration problems should not prevent the dive log from being served
+	_divelog.gearConfig = _gear_config
+	_divelog.trainingCatalogue = _training_catalogue
 }
 
+// buildDatabase reads and decodes the source file of _divelog.
 func buildDatabase() error {
-	path := _divelog.Metadata.Source
-	file, err := os.Open(path)
+	data, err := readDataFile(_divelog.Metadata.Source)
 	if err != nil {
-		return fmt.Errorf("failed to open file %s: %v", path, err)
+		return err
 	}
-	defer file.Close()
+	_divelog.Metadata.sourceHash = contentHash(data)
+	return decodeDatabase(data)
+}
 
-	if err = subsurface.DecodeSubsurfaceDatabase(file, &SubsurfaceCallbackHandler{}); err != nil {
+func decodeDatabase(data []byte) error {
+	path := _divelog.Metadata.Source
+	h := &SubsurfaceCallbackHandler{}
+	if err := subsurface.DecodeSubsurfaceDatabase(bytes.NewReader(data), h); err != nil {
 		return fmt.Errorf("failed to decode database in %s: %v", path, err)
 	}
-
+	if h.err != nil {
+		return fmt.Errorf("failed to build database from %s: %v", path, h.err)
+	}
 	return nil
 }
 
@@ -102,6 +275,22 @@ type SubsurfaceCallbackHandler struct {
 	lastSiteID int
 	lastTripID int
 	lastDiveID int
+
+	// IDs of the records added for dives with unknown sites or trips, if any
+	placeholderSite int
+	placeholderTrip int
+
+	// the first inconsistency found, which fails the build, as the CLI has no server to signal
+	err error
+}
+
+// ensure records an inconsistency of the build unless the condition holds, and returns the condition.
+func (p *SubsurfaceCallbackHandler) ensure(condition bool, errMsg string) bool {
+	if !condition && p.err == nil {
+		p.err = errors.New(errMsg)
+		trace(_error, "build inconsistency: %s", errMsg)
+	}
+	return condition
 }
 
 func (p *SubsurfaceCallbackHandler) HandleBegin() {
@@ -109,6 +298,9 @@ func (p *SubsurfaceCallbackHandler) HandleBegin() {
 	_divelog.DiveTrips = make([]*DiveTrip, 1, 100)
 	_divelog.Dives = make([]*Dive, 1, 100)
 	_divelog.sourceToSystemID = make(map[string]int)
+	_divelog.stableSiteIDs = make(map[string]int)
+	_divelog.stableTripIDs = make(map[string]int)
+	_divelog.stableDiveIDs = make(map[string]int)
 }
 
 func (p *SubsurfaceCallbackHandler) HandleDive(ddh subsurface.DiveDataHolder) int {
@@ -151,24 +343,40 @@ func (p *SubsurfaceCallbackHandler) HandleDive(ddh subsurface.DiveDataHolder) in
 		SurfacePressure: ddh.SurfacePressure,
 
 		datetime: ddh.DateTime,
+		duration: utils.ParseDuration(ddh.Duration),
+		depthMax: utils.ParseMeasurement(ddh.DepthMax),
+		cylinder: strings.TrimSpace(ddh.CylinderDescription + " " + ddh.CylinderSize),
 	}
-	trace(_build, "%v", dive)
-	assert(dive.ID == len(_divelog.Dives), "invalid Dive.ID")
+	traceDebug(_build, "dive built", "dive", dive)
+	p.ensure(dive.ID == len(_divelog.Dives), "invalid Dive.ID")
+
+	// unique once all dives are known
+	dive.StableID = diveStableID(ddh)
 
 	siteID, ok := _divelog.sourceToSystemID[ddh.DiveSiteUUID]
-	assert(ok, "DiveDataHolder.DiveSiteUUID is not mapped to DiveSite.ID")
+	if !ok {
+		siteID = p.placeholderSiteID()
+		reportIssue(IssueMissingSite, RecordDive, dive.ID, dive.StableID,
+			"dive site %q does not exist, linked to %q", ddh.DiveSiteUUID, UnknownDiveSiteName)
+	}
 	dive.DiveSiteID = siteID
-	assert(siteID > 0 && siteID < len(_divelog.DiveSites), "invalid dive site ID mapping")
-	assert(_divelog.DiveSites[siteID] != nil, "DiveSite ptr is nil")
-	trace(_link, "%v -> %v", dive, _divelog.DiveSites[siteID])
+	if p.ensure(siteID > 0 && siteID < len(_divelog.DiveSites) && _divelog.DiveSites[siteID] != nil, "invalid dive site ID mapping") {
+		traceDebug(_link, "dive linked to dive site", "dive", dive, "site", _divelog.DiveSites[siteID])
+	}
 
 	dive.DiveTripID = ddh.DiveTripID
-	assert(ddh.DiveTripID > 0 && ddh.DiveTripID < len(_divelog.DiveTrips), "invalid dive trip ID")
-	assert(_divelog.DiveTrips[ddh.DiveTripID] != nil, "DiveTrip ptr is nil")
-	trace(_link, "%v -> %v", dive, _divelog.DiveTrips[ddh.DiveTripID])
+	if ddh.DiveTripID <= 0 || ddh.DiveTripID >= len(_divelog.DiveTrips) {
+		dive.DiveTripID = p.placeholderTripID()
+		reportIssue(IssueMissingTrip, RecordDive, dive.ID, dive.StableID,
+			"dive trip %d does not exist, linked to %q", ddh.DiveTripID, UnknownDiveTripName)
+	}
+	if p.ensure(_divelog.DiveTrips[dive.DiveTripID] != nil, "DiveTrip ptr is nil") {
+		traceDebug(_link, "dive linked to dive trip", "dive", dive, "trip", _divelog.DiveTrips[dive.DiveTripID])
+	}
 
-	dive.ProcessSpecialTags(specialTags)
-	dive.Normalize()
+	dive.ProcessSpecialTags(specialTags, _divelog.mappings)
+	dive.Normalize(_divelog.mappings)
+	validatePressures(dive)
 
 	_divelog.Dives = append(_divelog.Dives, dive)
 	p.lastDiveID++
@@ -178,20 +386,21 @@ func (p *SubsurfaceCallbackHandler) HandleDive(ddh subsurface.DiveDataHolder) in
 
 func (p *SubsurfaceCallbackHandler) HandleDiveSite(uuid string, name string, coords string, description string) int {
 	region := UnlabeledRegion
-	if strings.HasPrefix(description, PrefixForTagsInDescription) {
+	mappings := _divelog.mappings
+	if strings.HasPrefix(description, mappings.PrefixForTagsInDescription) {
 		var specialTags string
 		if i := strings.IndexFunc(description, unicode.IsSpace); i != -1 {
-			specialTags = strings.TrimPrefix(description[:i], PrefixForTagsInDescription)
+			specialTags = strings.TrimPrefix(description[:i], mappings.PrefixForTagsInDescription)
 			description = strings.TrimSpace(description[i:])
 		} else {
-			specialTags = strings.TrimPrefix(description, PrefixForTagsInDescription)
+			specialTags = strings.TrimPrefix(description, mappings.PrefixForTagsInDescription)
 			description = ""
 		}
 
 		// DEVNOTE: DiveSite only supports one special tag for now: {RegionTagPrefix}{value}.
 		// If there arises a need for more, this will need to be refactored.
-		if after, ok := strings.CutPrefix(specialTags, RegionTagPrefix); ok {
-			if value, ok := SpecialTagValueMappings[after]; ok {
+		if after, ok := strings.CutPrefix(specialTags, mappings.RegionTagPrefix); ok {
+			if value, ok := mappings.SpecialTagValues[after]; ok {
 				region = value
 			}
 		}
@@ -210,11 +419,20 @@ func (p *SubsurfaceCallbackHandler) HandleDiveSite(uuid string, name string, coo
 
 		sourceID: uuid,
 	}
-	trace(_build, "%v", site)
-	assert(site.ID == len(_divelog.DiveSites), "invalid DiveSite.ID")
+	traceDebug(_build, "dive site built", "site", site)
+	p.ensure(site.ID == len(_divelog.DiveSites), "invalid DiveSite.ID")
+
+	// unique once all sites are known
+	site.StableID = siteStableID(uuid, name, coords)
+
+	if !validCoordinates(coords) {
+		reportIssue(IssueInvalidCoordinates, RecordDiveSite, site.ID, site.StableID,
+			"coordinates %q are not in the \"{latitude} {longitude}\" format, coordinates dropped", coords)
+		site.Coordinates = ""
+	}
 
 	_divelog.sourceToSystemID[site.sourceID] = site.ID
-	trace(_map, "sourceToSystemID %q -> %d", site.sourceID, site.ID)
+	traceDebug(_map, "source dive site ID mapped", "source_id", site.sourceID, "site", site.ID)
 
 	_divelog.DiveSites = append(_divelog.DiveSites, site)
 	p.lastSiteID++
@@ -227,8 +445,8 @@ func (p *SubsurfaceCallbackHandler) HandleDiveTrip(label string) int {
 		ID:    p.lastTripID + 1,
 		Label: label,
 	}
-	trace(_build, "%v", trip)
-	assert(trip.ID == len(_divelog.DiveTrips), "invalid DiveTrip.ID")
+	traceDebug(_build, "dive trip built", "trip", trip)
+	p.ensure(trip.ID == len(_divelog.DiveTrips), "invalid DiveTrip.ID")
 
 	_divelog.DiveTrips = append(_divelog.DiveTrips, trip)
 	p.lastTripID++
@@ -237,13 +455,27 @@ func (p *SubsurfaceCallbackHandler) HandleDiveTrip(label string) int {
 }
 
 func (p *SubsurfaceCallbackHandler) HandleEnd() {
-	assert(len(_divelog.Dives)-1 == p.lastDiveID, "invalid Dives slice length")
-	assert(len(_divelog.DiveSites)-1 == p.lastSiteID, "invalid DiveSites slice length")
-	assert(len(_divelog.DiveTrips)-1 == p.lastTripID, "invalid DiveTrips slice length")
+	p.ensure(len(_divelog.Dives)-1 == p.lastDiveID, "invalid Dives slice length")
+	p.ensure(len(_divelog.DiveSites)-1 == p.lastSiteID, "invalid DiveSites slice length")
+	p.ensure(len(_divelog.DiveTrips)-1 == p.lastTripID, "invalid DiveTrips slice length")
+	if p.err != nil {
+		// the build fails, and the dive log cannot be indexed
+		return
+	}
+
+	assignSiteStableIDs(_divelog)
+	assignDiveStableIDs(_divelog)
+	assignTripStableIDs(_divelog)
+
+	validateDives(_divelog, time.Now().UTC())
+	DetectAwards(_divelog, _control_block.autoAwards)
+	_divelog.index = newDiveIndex(_divelog)
 }
 
 func (p *SubsurfaceCallbackHandler) HandleGeoData(siteID int, cat int, label string) {
-	assert(_divelog.DiveSites[siteID] != nil, "DiveSite ptr is nil")
+	if !p.ensure(siteID > 0 && siteID < len(_divelog.DiveSites) && _divelog.DiveSites[siteID] != nil, "invalid dive site ID of geo data") {
+		return
+	}
 	site := _divelog.DiveSites[siteID]
 	for _, lbl := range site.GeoLabels {
 		if lbl == label {
@@ -263,43 +495,138 @@ func (p *SubsurfaceCallbackHandler) HandleSkip(element string) {
 	// do nothing
 }
 
-func findLatestDataFile() (path string, mt time.Time, err error) {
+// assignSiteStableIDs makes the stable IDs derived for the sites unique, ordering sites
+// which derive the same stable ID by their names, coordinates and descriptions.
+func assignSiteStableIDs(divelog *DiveLog) {
+	claims := make([]stableIDClaim, 0, len(divelog.DiveSites)-1)
+	for _, site := range divelog.DiveSites[1:] {
+		claims = append(claims, stableIDClaim{
+			id:       site.ID,
+			stableID: site.StableID,
+			order:    strings.Join([]string{site.Name, site.Coordinates, site.Description}, "\x00"),
+		})
+	}
+	stableIDs := assignStableIDs(divelog.stableSiteIDs, claims)
+	for _, site := range divelog.DiveSites[1:] {
+		site.StableID = stableIDs[site.ID]
+		traceDebug(_map, "stable dive site ID assigned", "stable_id", site.StableID, "site", site.ID)
+	}
+	updateIssueStableIDs(divelog, RecordDiveSite, func(id int) string { return divelog.DiveSites[id].StableID })
+}
+
+// assignDiveStableIDs makes the stable IDs derived for the dives unique, ordering dives
+// which derive the same stable ID by their start times and numbers.
+func assignDiveStableIDs(divelog *DiveLog) {
+	claims := make([]stableIDClaim, 0, len(divelog.Dives)-1)
+	for _, dive := range divelog.Dives[1:] {
+		claims = append(claims, stableIDClaim{
+			id:       dive.ID,
+			stableID: dive.StableID,
+			order:    fmt.Sprintf("%s\x00%010d", dive.DateTimeIn, dive.Number),
+		})
+	}
+	stableIDs := assignStableIDs(divelog.stableDiveIDs, claims)
+	for _, dive := range divelog.Dives[1:] {
+		dive.StableID = stableIDs[dive.ID]
+		traceDebug(_map, "stable dive ID assigned", "stable_id", dive.StableID, "dive", dive.ID)
+	}
+	updateIssueStableIDs(divelog, RecordDive, func(id int) string { return divelog.Dives[id].StableID })
+}
+
+// assignTripStableIDs derives the stable ID of every trip from its label, which is the only
+// identity of a trip in the source file, so renaming a trip changes its permalink. Trips with
+// the same label are ordered by their earliest dives. Dives must have their stable IDs.
+func assignTripStableIDs(divelog *DiveLog) {
+	first := make([]*Dive, len(divelog.DiveTrips))
+	for _, dive := range divelog.Dives[1:] {
+		trip := dive.DiveTripID
+		if f := first[trip]; f == nil || dive.datetime.Before(f.datetime) ||
+			dive.datetime.Equal(f.datetime) && dive.StableID < f.StableID {
+			first[trip] = dive
+		}
+	}
+
+	claims := make([]stableIDClaim, 0, len(divelog.DiveTrips)-1)
+	for _, trip := range divelog.DiveTrips[1:] {
+		claim := stableIDClaim{id: trip.ID, stableID: utils.LongHash("trip", trip.Label)}
+		if f := first[trip.ID]; f != nil {
+			claim.order = f.DateTimeIn + "\x00" + f.StableID
+		}
+		claims = append(claims, claim)
+	}
+	stableIDs := assignStableIDs(divelog.stableTripIDs, claims)
+	for _, trip := range divelog.DiveTrips[1:] {
+		trip.StableID = stableIDs[trip.ID]
+		traceDebug(_map, "stable dive trip ID assigned", "stable_id", trip.StableID, "trip", trip.ID)
+	}
+}
+
+// updateIssueStableIDs sets the stable IDs of the records of issues reported before
+// the stable IDs were made unique.
+func updateIssueStableIDs(divelog *DiveLog, record string, stableIDOf func(id int) string) {
+	for _, issue := range divelog.issues {
+		if issue.Record == record {
+			issue.StableID = stableIDOf(issue.ID)
+		}
+	}
+}
+
+// siteStableID prefers the UUID assigned to the site by Subsurface.
+func siteStableID(uuid string, name string, coords string) string {
+	if uuid = strings.ToLower(strings.TrimSpace(uuid)); uuid != "" {
+		return uuid
+	}
+	return utils.LongHash(name, coords)
+}
+
+// diveStableID prefers the dive ID (fingerprint) assigned by the dive computer,
+// then the dive computer device ID combined with the dive start time,
+// and finally the dive number combined with the dive start time.
+func diveStableID(ddh subsurface.DiveDataHolder) string {
+	if diveID := strings.ToLower(strings.TrimSpace(ddh.DiveComputerDiveID)); diveID != "" {
+		return diveID
+	}
+	dateTime := ddh.DateTime.Format(time.RFC3339)
+	if ddh.DiveComputerDeviceID != "" {
+		return utils.LongHash(ddh.DiveComputerDeviceID, dateTime)
+	}
+	return utils.LongHash(strconv.Itoa(ddh.DiveNumber), dateTime)
+}
+
+// findDataFiles returns the data files in the watch directory, from the newest to the oldest.
+func findDataFiles() ([]*dataFile, error) {
 	directoryPath := _control_block.watchDirectoryPath
 	entries, err := os.ReadDir(directoryPath)
 	if err != nil {
-		return
+		return nil, err
 	}
 
+	var files []*dataFile
 	for _, entry := range entries {
-		if entry.IsDir() {
+		if entry.IsDir() || !isDataFileName(entry.Name()) {
 			continue
 		}
 
-		name := entry.Name()
-		if !strings.HasPrefix(name, SubsurfaceDataFilePrefix) {
-			continue
-		}
-
-		info, infoErr := entry.Info()
-		if infoErr != nil {
-			err = infoErr
-			return
-		}
-
-		modTime := info.ModTime()
-		if path == "" || modTime.After(mt) {
-			mt = modTime
-			path = filepath.Join(directoryPath, name)
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
 		}
+		files = append(files, &dataFile{
+			path:    filepath.Join(directoryPath, entry.Name()),
+			modTime: info.ModTime(),
+		})
 	}
 
-	if path == "" {
-		err = fmt.Errorf(
+	if len(files) == 0 {
+		return nil, fmt.Errorf(
 			"no files with prefix %q found in %s",
-			SubsurfaceDataFilePrefix,
+			_control_block.dataFilePrefix,
 			directoryPath,
 		)
 	}
 
-	return
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].modTime.After(files[j].modTime)
+	})
+	return files, nil
 }
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"src.acicovic.me/divelog/server/utils"
+)
+
+// testTrip is a trip with the stable IDs of its dives.
+type testTrip struct {
+	label string
+	dives []string
+}
+
+// tripTestLog returns a dive log with the trips, whose dives are a day apart in the order of
+// their stable IDs, with stable trip IDs assigned.
+func tripTestLog(trips ...testTrip) *DiveLog {
+	divelog := &DiveLog{
+		DiveTrips:     []*DiveTrip{nil},
+		Dives:         []*Dive{nil},
+		stableTripIDs: make(map[string]int),
+		stableDiveIDs: make(map[string]int),
+	}
+	var stableIDs []string
+	for _, trip := range trips {
+		stableIDs = append(stableIDs, trip.dives...)
+	}
+	slices.Sort(stableIDs)
+
+	first := time.Date(2023, time.January, 1, 10, 0, 0, 0, time.UTC)
+	for _, trip := range trips {
+		tripID := len(divelog.DiveTrips)
+		divelog.DiveTrips = append(divelog.DiveTrips, &DiveTrip{ID: tripID, Label: trip.label})
+		for _, stableID := range trip.dives {
+			day := first.AddDate(0, 0, slices.Index(stableIDs, stableID))
+			dive := &Dive{ID: len(divelog.Dives), StableID: stableID, DiveTripID: tripID,
+				DateTimeIn: day.Format(time.RFC3339), datetime: day}
+			divelog.Dives = append(divelog.Dives, dive)
+			divelog.stableDiveIDs[stableID] = dive.ID
+		}
+	}
+	assignTripStableIDs(divelog)
+	return divelog
+}
+
+// tripOf returns the stable ID of the trip of the dive with the stable ID.
+func tripOf(divelog *DiveLog, diveStableID string) string {
+	dive := divelog.Dives[divelog.stableDiveIDs[diveStableID]]
+	return divelog.DiveTrips[dive.DiveTripID].StableID
+}
+
+func TestAssignTripStableIDs(t *testing.T) {
+	previous := tripTestLog(
+		testTrip{"Vis", []string{"a1", "a2", "a3"}},
+		testTrip{"Lastovo", []string{"b1", "b2"}},
+		testTrip{"Vis", []string{"c1"}},
+	)
+	vis, lastovo := utils.LongHash("trip", "Vis"), utils.LongHash("trip", "Lastovo")
+	if got := []string{tripOf(previous, "a1"), tripOf(previous, "b1"), tripOf(previous, "c1")}; got[0] != vis || got[1] != lastovo || got[2] != vis+"-2" {
+		t.Fatalf("stable trip IDs = %q, want %q, %q and %q", got, vis, lastovo, vis+"-2")
+	}
+
+	tests := []struct {
+		name   string
+		latest []testTrip
+		// dive stable ID in the latest snapshot -> dive stable ID in the previous snapshot
+		// whose trip stable ID is kept, or "" if the trip gets a new stable ID
+		kept map[string]string
+	}{
+		{
+			name:   "unchanged",
+			latest: []testTrip{{"Vis", []string{"a1", "a2", "a3"}}, {"Lastovo", []string{"b1", "b2"}}, {"Vis", []string{"c1"}}},
+			kept:   map[string]string{"a1": "a1", "b1": "b1", "c1": "c1"},
+		},
+		{
+			name:   "renamed",
+			latest: []testTrip{{"Vis", []string{"a1", "a2", "a3"}}, {"Lastovo 2023", []string{"b1", "b2"}}, {"Vis", []string{"c1"}}},
+			kept:   map[string]string{"a1": "a1", "b1": "", "c1": "c1"},
+		},
+		{
+			name:   "earlier dive added",
+			latest: []testTrip{{"Vis", []string{"a0", "a1", "a2", "a3"}}, {"Lastovo", []string{"b1", "b2"}}, {"Vis", []string{"c1"}}},
+			kept:   map[string]string{"a0": "a1", "b1": "b1", "c1": "c1"},
+		},
+		{
+			name:   "reordered in the source file",
+			latest: []testTrip{{"Vis", []string{"c1"}}, {"Lastovo", []string{"b1", "b2"}}, {"Vis", []string{"a1", "a2", "a3"}}},
+			kept:   map[string]string{"a1": "a1", "b1": "b1", "c1": "c1"},
+		},
+		{
+			name:   "new trip",
+			latest: []testTrip{{"Vis", []string{"a1", "a2", "a3"}}, {"Lastovo", []string{"b1", "b2"}}, {"Vis", []string{"c1"}}, {"Mljet", []string{"d1"}}},
+			kept:   map[string]string{"a1": "a1", "b1": "b1", "c1": "c1", "d1": ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			latest := tripTestLog(tt.latest...)
+			for dive, previousDive := range tt.kept {
+				got := tripOf(latest, dive)
+				if previousDive != "" {
+					if want := tripOf(previous, previousDive); got != want {
+						t.Errorf("trip of %s = %q, want %q", dive, got, want)
+					}
+					continue
+				}
+				for _, trip := range previous.DiveTrips[1:] {
+					if got == trip.StableID {
+						t.Errorf("trip of %s = %q, want a new stable ID", dive, got)
+					}
+				}
+			}
+			if len(latest.stableTripIDs) != len(latest.DiveTrips)-1 {
+				t.Errorf("%d stable trip IDs for %d trips", len(latest.stableTripIDs), len(latest.DiveTrips)-1)
+			}
+		})
+	}
+}
+
+func TestAssignStableIDs(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims []stableIDClaim
+		want   []string // by system ID
+	}{
+		{
+			name:   "unique",
+			claims: []stableIDClaim{{1, "b", ""}, {2, "a", ""}},
+			want:   []string{"", "b", "a"},
+		},
+		{
+			name:   "collision ordered by source data",
+			claims: []stableIDClaim{{1, "a", "2024"}, {2, "a", "2023"}},
+			want:   []string{"", "a-2", "a"},
+		},
+		{
+			name:   "collision in the other file order",
+			claims: []stableIDClaim{{1, "a", "2023"}, {2, "a", "2024"}},
+			want:   []string{"", "a", "a-2"},
+		},
+		{
+			name:   "same source data in file order",
+			claims: []stableIDClaim{{1, "a", "2023"}, {2, "a", "2023"}},
+			want:   []string{"", "a", "a-2"},
+		},
+		{
+			name:   "suffix taken",
+			claims: []stableIDClaim{{1, "a-2", ""}, {2, "a", "2"}, {3, "a", "1"}},
+			want:   []string{"", "a-2-2", "a-2", "a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index := make(map[string]int)
+			got := assignStableIDs(index, tt.claims)
+			for id := 1; id < len(tt.want); id++ {
+				if got[id] != tt.want[id] {
+					t.Errorf("stable ID of %d = %q, want %q", id, got[id], tt.want[id])
+				}
+				if index[got[id]] != id {
+					t.Errorf("index[%q] = %d, want %d", got[id], index[got[id]], id)
+				}
+			}
+		})
+	}
+}
+
+// An inconsistent build fails with an error instead of signaling the server, which the CLI does not run.
+func TestSubsurfaceCallbackHandlerInconsistency(t *testing.T) {
+	previous := _divelog
+	t.Cleanup(func() { _divelog = previous })
+	newDiveLog("test.xml", time.Now())
+
+	h := &SubsurfaceCallbackHandler{}
+	h.HandleBegin()
+	siteID := h.HandleDiveSite("00000001", "Vis", "", "")
+	h.HandleGeoData(siteID, 0, "Croatia")
+	h.HandleGeoData(siteID+1, 0, "Croatia")
+	h.HandleEnd()
+
+	if h.err == nil {
+		t.Fatal("no error for geo data of an unknown dive site")
+	}
+	if labels := _divelog.DiveSites[siteID].GeoLabels; !slices.Equal(labels, []string{"Croatia"}) {
+		t.Errorf("geo labels = %q, want the label of the known dive site", labels)
+	}
+	if _divelog.index != nil {
+		t.Errorf("inconsistent dive log indexed")
+	}
+}
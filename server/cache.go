@@ -0,0 +1,324 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Snapshots built by the builder are stored in the cache directory, keyed by the content hash
+// of the source file and of the other build inputs (mappings, training catalogue and settings).
+// On boot, the snapshot of the newest data file found in the cache (or of the data file
+// rolled back to, see snapshots.go) is served at once, and newer data files are built
+// in the background.
+
+const (
+	// changed whenever cached snapshots would differ from builds, e.g. with other stable IDs
+	cacheFormatVersion   = 3
+	maxCachedSnapshots   = 3
+	cachedSnapshotPrefix = "snapshot-"
+	cachedSnapshotSuffix = ".gob"
+)
+
+var errCacheMiss = errors.New("snapshot not cached")
+
+// cachedSnapshot mirrors DiveLog with exported fields only, as gob ignores unexported fields.
+// Slices do not include the unused first element, as gob does not encode nil elements.
+type cachedSnapshot struct {
+	Version          int
+	Metadata         DiveLogMetadata
+	BuiltAt          time.Time
+	DiveSites        []*cachedDiveSite
+	DiveTrips        []*DiveTrip
+	Dives            []*cachedDive
+	SourceToSystemID map[string]int
+	StableSiteIDs    map[string]int
+	StableTripIDs    map[string]int
+	StableDiveIDs    map[string]int
+	Issues           []*ValidationIssue
+}
+
+type cachedDiveSite struct {
+	DiveSite *DiveSite
+	SourceID string
+}
+
+type cachedDive struct {
+	Dive         *Dive
+	DateTime     time.Time
+	Duration     time.Duration
+	DepthMax     float64
+	Cylinder     string
+	Gear         []string
+	Certs        [][2]string
+	Courses      []string
+	ManualAwards []string
+	AutoAwards   []string
+}
+
+// snapshotCacheKey identifies a build of the source file with the build inputs of the dive log.
+// Gear configuration is not a build input, it is loaded for every snapshot.
+func snapshotCacheKey(sourceHash string, divelog *DiveLog) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\n%s\n%t\n", cacheFormatVersion, sourceHash, _control_block.autoAwards)
+	encoder := json.NewEncoder(h)
+	encoder.Encode(divelog.mappings)
+	encoder.Encode(divelog.trainingCatalogue)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func cachedSnapshotPath(key string) string {
+	return filepath.Join(_control_block.cacheDir, cachedSnapshotPrefix+key+cachedSnapshotSuffix)
+}
+
+// storeSnapshot writes the snapshot to the cache directory, replacing the oldest cached snapshots.
+// Failures are only traced, as the cache is an optimization.
+func storeSnapshot(divelog *DiveLog, key string) {
+	if _control_block.cacheDir == "" {
+		return
+	}
+	if err := writeCachedSnapshot(divelog, key); err != nil {
+		trace(_error, "failed to cache snapshot: %v", err)
+		return
+	}
+	trace(_build, "snapshot cached as %s", cachedSnapshotPath(key))
+	pruneCachedSnapshots()
+}
+
+func writeCachedSnapshot(divelog *DiveLog, key string) error {
+	if err := os.MkdirAll(_control_block.cacheDir, 0o755); err != nil {
+		return err
+	}
+
+	cached := &cachedSnapshot{
+		Version:          cacheFormatVersion,
+		Metadata:         divelog.Metadata,
+		BuiltAt:          divelog.Metadata.builtAt,
+		DiveTrips:        divelog.DiveTrips[1:],
+		SourceToSystemID: divelog.sourceToSystemID,
+		StableSiteIDs:    divelog.stableSiteIDs,
+		StableTripIDs:    divelog.stableTripIDs,
+		StableDiveIDs:    divelog.stableDiveIDs,
+		Issues:           divelog.issues,
+	}
+	for _, site := range divelog.DiveSites[1:] {
+		cached.DiveSites = append(cached.DiveSites, &cachedDiveSite{DiveSite: site, SourceID: site.sourceID})
+	}
+	for _, dive := range divelog.Dives[1:] {
+		certs := make([][2]string, 0, len(dive.certs))
+		for _, cert := range dive.certs {
+			certs = append(certs, [2]string{cert.agency, cert.level})
+		}
+		cached.Dives = append(cached.Dives, &cachedDive{
+			Dive:         dive,
+			DateTime:     dive.datetime,
+			Duration:     dive.duration,
+			DepthMax:     dive.depthMax,
+			Cylinder:     dive.cylinder,
+			Gear:         dive.gear,
+			Certs:        certs,
+			Courses:      dive.courses,
+			ManualAwards: dive.manualAwards,
+			AutoAwards:   dive.autoAwards,
+		})
+	}
+
+	// written under a temporary name, so that a partially written file is never loaded
+	file, err := os.CreateTemp(_control_block.cacheDir, "."+cachedSnapshotPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if err := gob.NewEncoder(file).Encode(cached); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), cachedSnapshotPath(key))
+}
+
+// loadSnapshot fills _divelog, prepared by newDiveLog, from the cached snapshot.
+func loadSnapshot(key string) error {
+	return decodeSnapshot(cachedSnapshotPath(key), _divelog)
+}
+
+// decodeSnapshot fills the dive log from the cached snapshot file.
+func decodeSnapshot(path string, divelog *DiveLog) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return errCacheMiss
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	cached := &cachedSnapshot{}
+	if err := gob.NewDecoder(file).Decode(cached); err != nil {
+		return fmt.Errorf("failed to decode cached snapshot %s: %v", file.Name(), err)
+	}
+	if cached.Version != cacheFormatVersion {
+		return errCacheMiss
+	}
+
+	divelog.Metadata.Source = cached.Metadata.Source
+	divelog.Metadata.ModificationTime = cached.Metadata.ModificationTime
+	divelog.Metadata.Program = cached.Metadata.Program
+	divelog.Metadata.ProgramVersion = cached.Metadata.ProgramVersion
+	divelog.Metadata.Units = cached.Metadata.Units
+	divelog.Metadata.builtAt = cached.BuiltAt
+	divelog.DiveSites = make([]*DiveSite, 1, len(cached.DiveSites)+1)
+	divelog.DiveTrips = append(make([]*DiveTrip, 1, len(cached.DiveTrips)+1), cached.DiveTrips...)
+	divelog.Dives = make([]*Dive, 1, len(cached.Dives)+1)
+	divelog.sourceToSystemID = cached.SourceToSystemID
+	divelog.stableSiteIDs = cached.StableSiteIDs
+	divelog.stableTripIDs = cached.StableTripIDs
+	divelog.stableDiveIDs = cached.StableDiveIDs
+	divelog.issues = cached.Issues
+
+	for _, site := range cached.DiveSites {
+		site.DiveSite.sourceID = site.SourceID
+		divelog.DiveSites = append(divelog.DiveSites, site.DiveSite)
+	}
+	for _, cachedDive := range cached.Dives {
+		dive := cachedDive.Dive
+		dive.datetime = cachedDive.DateTime
+		dive.duration = cachedDive.Duration
+		dive.depthMax = cachedDive.DepthMax
+		dive.cylinder = cachedDive.Cylinder
+		dive.gear = cachedDive.Gear
+		dive.courses = cachedDive.Courses
+		dive.manualAwards = cachedDive.ManualAwards
+		dive.autoAwards = cachedDive.AutoAwards
+		for _, cert := range cachedDive.Certs {
+			dive.certs = append(dive.certs, certTag{agency: cert[0], level: cert[1]})
+		}
+		divelog.Dives = append(divelog.Dives, dive)
+	}
+
+	// the index is not cached, it is cheap to compute compared to decoding
+	divelog.index = newDiveIndex(divelog)
+	return nil
+}
+
+// loadCachedSnapshot publishes the snapshot of the newest data file found in the cache.
+func loadCachedSnapshot() bool {
+	if _control_block.cacheDir == "" {
+		return false
+	}
+	if matches, _ := filepath.Glob(cachedSnapshotPath("*")); len(matches) == 0 {
+		return false
+	}
+	candidates, err := findDataFiles()
+	if err != nil {
+		return false
+	}
+	reloadMappings()
+	reloadGearConfig()
+	reloadTrainingCatalogue()
+
+	// a rollback kept from the previous run is served instead of the newest data file
+	rb := pendingRollback()
+	for _, candidate := range candidates {
+		if rb != nil && candidate.path != rb.Source {
+			continue
+		}
+		start := time.Now()
+		data, err := readDataFile(candidate.path)
+		if err != nil {
+			continue
+		}
+		hash := contentHash(data)
+		if rb != nil && hash != rb.SourceHash {
+			continue
+		}
+		newDiveLog(candidate.path, candidate.modTime)
+		_divelog.Metadata.sourceHash = hash
+
+		key := snapshotCacheKey(hash, _divelog)
+		if err := loadSnapshot(key); err != nil {
+			if !errors.Is(err, errCacheMiss) {
+				trace(_error, "%v", err)
+			}
+			continue
+		}
+		_divelog.Metadata.buildDuration = time.Since(start)
+		publishSnapshot(_divelog)
+		trace(_build, "snapshot of %s loaded from the cache in %s", candidate.path, _divelog.Metadata.buildDuration)
+		return true
+	}
+	trace(_build, "no cached snapshots of data files in %s", _control_block.watchDirectoryPath)
+	return false
+}
+
+// loadNewestCachedSnapshot returns the most recently written cached snapshot, if any.
+func loadNewestCachedSnapshot() *DiveLog {
+	for _, path := range cachedSnapshotPaths() {
+		divelog := &DiveLog{}
+		if err := decodeSnapshot(path, divelog); err != nil {
+			if !errors.Is(err, errCacheMiss) {
+				trace(_error, "%v", err)
+			}
+			continue
+		}
+		return divelog
+	}
+	return nil
+}
+
+// cachedSnapshotPaths returns the paths of cached snapshots, from the most recently written.
+func cachedSnapshotPaths() []string {
+	matches, err := filepath.Glob(cachedSnapshotPath("*"))
+	if err != nil {
+		return nil
+	}
+
+	modTimes := make(map[string]time.Time, len(matches))
+	for _, path := range matches {
+		if info, err := os.Stat(path); err == nil {
+			modTimes[path] = info.ModTime()
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return modTimes[matches[i]].After(modTimes[matches[j]])
+	})
+	return matches
+}
+
+// pruneCachedSnapshots removes all but the most recently written cached snapshots.
+func pruneCachedSnapshots() {
+	matches := cachedSnapshotPaths()
+	if len(matches) <= maxCachedSnapshots {
+		return
+	}
+	for _, path := range matches[maxCachedSnapshots:] {
+		os.Remove(path)
+	}
+}
+
+// writeFileAtomically writes the file under a temporary name and then renames it,
+// so that a partially written file is never read.
+func writeFileAtomically(path string, data []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
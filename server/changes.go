@@ -0,0 +1,293 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Every snapshot built by the builder is compared with the previous newest snapshot, by stable IDs,
+// and the differences are recorded as a change set. Rollbacks do not record change sets, and
+// rebuilds without differences (e.g. forced rebuilds) are not recorded.
+// Change sets are kept in the cache directory across restarts, and the first snapshot built on boot
+// is compared with the newest cached snapshot, which is the last one built by the previous run.
+// Generations restart with every run, so change sets of previous runs are told apart by BuiltAt.
+
+const (
+	maxChangeSets     = 100
+	changeLogFileName = "changes.json"
+)
+
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeEdited  = "edited"
+)
+
+type ChangeSet struct {
+	Generation     uint64        `json:"generation"`
+	FromGeneration uint64        `json:"from_generation"`
+	Source         string        `json:"source"`
+	BuiltAt        string        `json:"built_at"`
+	Dives          []*DiveChange `json:"dives"`
+	DiveSites      []*SiteChange `json:"dive_sites"`
+	DiveTrips      []*TripChange `json:"dive_trips"`
+}
+
+// ChangeLog lists the change sets from the newest to the oldest.
+type ChangeLog struct {
+	ChangeSets []*ChangeSet
+}
+
+type DiveChange struct {
+	Change   string         `json:"change"`
+	StableID string         `json:"stable_id"`
+	Label    string         `json:"label"`
+	Fields   []*FieldChange `json:"fields,omitempty"`
+}
+
+type SiteChange struct {
+	Change   string         `json:"change"`
+	StableID string         `json:"stable_id"`
+	Name     string         `json:"name"`
+	Fields   []*FieldChange `json:"fields,omitempty"`
+}
+
+type TripChange struct {
+	Change   string         `json:"change"`
+	StableID string         `json:"stable_id"`
+	Label    string         `json:"label"`
+	Fields   []*FieldChange `json:"fields,omitempty"`
+}
+
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// Linked sites and trips are compared by name, as system IDs are assigned in file order.
+var _dive_change_fields = []struct {
+	name  string
+	value func(d *Dive, dl *DiveLog) string
+}{
+	{"number", func(d *Dive, _ *DiveLog) string { return strconv.Itoa(d.Number) }},
+	{"date_time_in", func(d *Dive, _ *DiveLog) string { return d.DateTimeIn }},
+	{"duration", func(d *Dive, _ *DiveLog) string { return d.Duration }},
+	{"site", func(d *Dive, dl *DiveLog) string { return dl.DiveSites[d.DiveSiteID].Name }},
+	{"trip", func(d *Dive, dl *DiveLog) string { return dl.DiveTrips[d.DiveTripID].Label }},
+	{"depth_max", func(d *Dive, _ *DiveLog) string { return d.DepthMax }},
+	{"depth_mean", func(d *Dive, _ *DiveLog) string { return d.DepthMean }},
+	{"temp_water_min", func(d *Dive, _ *DiveLog) string { return d.TempWaterMin }},
+	{"temp_air", func(d *Dive, _ *DiveLog) string { return d.TempAir }},
+	{"salinity", func(d *Dive, _ *DiveLog) string { return d.Salinity }},
+	{"buddy", func(d *Dive, _ *DiveLog) string { return d.Buddy }},
+	{"operator_dm", func(d *Dive, _ *DiveLog) string { return d.OperatorDM }},
+	{"suit", func(d *Dive, _ *DiveLog) string { return d.Suit }},
+	{"cyl_size", func(d *Dive, _ *DiveLog) string { return d.CylSize }},
+	{"cyl_type", func(d *Dive, _ *DiveLog) string { return d.CylType }},
+	{"start_pressure", func(d *Dive, _ *DiveLog) string { return d.StartPressure }},
+	{"end_pressure", func(d *Dive, _ *DiveLog) string { return d.EndPressure }},
+	{"gas", func(d *Dive, _ *DiveLog) string { return d.Gas }},
+	{"weights", func(d *Dive, _ *DiveLog) string { return d.Weights }},
+	{"weights_type", func(d *Dive, _ *DiveLog) string { return d.WeightsType }},
+	{"dc_model", func(d *Dive, _ *DiveLog) string { return d.DCModel }},
+	{"rating5", func(d *Dive, _ *DiveLog) string { return strconv.Itoa(d.Rating5) }},
+	{"visibility5", func(d *Dive, _ *DiveLog) string { return strconv.Itoa(d.Visibility5) }},
+	{"tags", func(d *Dive, _ *DiveLog) string { return strings.Join(d.Tags, ", ") }},
+	{"award", func(d *Dive, _ *DiveLog) string { return d.Award }},
+	{"notes", func(d *Dive, _ *DiveLog) string { return d.Notes }},
+}
+
+var _site_change_fields = []struct {
+	name  string
+	value func(s *DiveSite) string
+}{
+	{"name", func(s *DiveSite) string { return s.Name }},
+	{"coordinates", func(s *DiveSite) string { return s.Coordinates }},
+	{"region", func(s *DiveSite) string { return s.Region }},
+	{"description", func(s *DiveSite) string { return s.Description }},
+}
+
+var _changelog = struct {
+	sync.Mutex
+	changes []*ChangeSet
+}{}
+
+// recordChanges records the differences between the previous and the latest snapshot, if any.
+// The previous snapshot has generation 0 if it was built by the previous run.
+func recordChanges(previous *DiveLog, latest *DiveLog) {
+	since := "snapshot " + strconv.FormatUint(previous.Metadata.generation, 10)
+	if previous.Metadata.generation == 0 {
+		since = "the previous run"
+	}
+	changes := DiffSnapshots(previous, latest)
+	if len(changes.Dives) == 0 && len(changes.DiveSites) == 0 && len(changes.DiveTrips) == 0 {
+		trace(_build, "no changes since %s", since)
+		return
+	}
+	trace(_build, "changes since %s: %d dives, %d dive sites, %d dive trips",
+		since, len(changes.Dives), len(changes.DiveSites), len(changes.DiveTrips))
+
+	c := &_changelog
+	c.Lock()
+	defer c.Unlock()
+	c.changes = append([]*ChangeSet{changes}, c.changes...)
+	if len(c.changes) > maxChangeSets {
+		c.changes = c.changes[:maxChangeSets]
+	}
+	storeChangeLog(c.changes)
+}
+
+// changeSets returns the recorded change sets, from the newest to the oldest.
+func changeSets() []*ChangeSet {
+	c := &_changelog
+	c.Lock()
+	defer c.Unlock()
+	return append([]*ChangeSet{}, c.changes...)
+}
+
+func DiffSnapshots(previous *DiveLog, latest *DiveLog) *ChangeSet {
+	changes := &ChangeSet{
+		Generation:     latest.Metadata.generation,
+		FromGeneration: previous.Metadata.generation,
+		Source:         latest.Metadata.Source,
+		BuiltAt:        latest.Metadata.builtAt.Format(time.RFC3339),
+		Dives:          []*DiveChange{},
+		DiveSites:      []*SiteChange{},
+		DiveTrips:      []*TripChange{},
+	}
+
+	for _, site := range latest.DiveSites[1:] {
+		id, ok := previous.stableSiteIDs[site.StableID]
+		if !ok {
+			changes.DiveSites = append(changes.DiveSites, &SiteChange{Change: ChangeAdded, StableID: site.StableID, Name: site.Name})
+			continue
+		}
+		old := previous.DiveSites[id]
+		var fields []*FieldChange
+		for _, field := range _site_change_fields {
+			if before, after := field.value(old), field.value(site); before != after {
+				fields = append(fields, &FieldChange{Field: field.name, Old: before, New: after})
+			}
+		}
+		if fields != nil {
+			changes.DiveSites = append(changes.DiveSites, &SiteChange{Change: ChangeEdited, StableID: site.StableID, Name: site.Name, Fields: fields})
+		}
+	}
+	for _, site := range previous.DiveSites[1:] {
+		if _, ok := latest.stableSiteIDs[site.StableID]; !ok {
+			changes.DiveSites = append(changes.DiveSites, &SiteChange{Change: ChangeRemoved, StableID: site.StableID, Name: site.Name})
+		}
+	}
+
+	for _, trip := range latest.DiveTrips[1:] {
+		id, ok := previous.stableTripIDs[trip.StableID]
+		if !ok {
+			changes.DiveTrips = append(changes.DiveTrips, &TripChange{Change: ChangeAdded, StableID: trip.StableID, Label: trip.Label})
+			continue
+		}
+		if old := previous.DiveTrips[id]; old.Label != trip.Label {
+			changes.DiveTrips = append(changes.DiveTrips, &TripChange{Change: ChangeEdited, StableID: trip.StableID, Label: trip.Label,
+				Fields: []*FieldChange{{Field: "label", Old: old.Label, New: trip.Label}}})
+		}
+	}
+	for _, trip := range previous.DiveTrips[1:] {
+		if _, ok := latest.stableTripIDs[trip.StableID]; !ok {
+			changes.DiveTrips = append(changes.DiveTrips, &TripChange{Change: ChangeRemoved, StableID: trip.StableID, Label: trip.Label})
+		}
+	}
+
+	for _, dive := range latest.Dives[1:] {
+		label := NewDiveHead(dive, latest.DiveSites[dive.DiveSiteID]).ShortLabel
+		id, ok := previous.stableDiveIDs[dive.StableID]
+		if !ok {
+			changes.Dives = append(changes.Dives, &DiveChange{Change: ChangeAdded, StableID: dive.StableID, Label: label})
+			continue
+		}
+		old := previous.Dives[id]
+		var fields []*FieldChange
+		for _, field := range _dive_change_fields {
+			if before, after := field.value(old, previous), field.value(dive, latest); before != after {
+				fields = append(fields, &FieldChange{Field: field.name, Old: before, New: after})
+			}
+		}
+		if fields != nil {
+			changes.Dives = append(changes.Dives, &DiveChange{Change: ChangeEdited, StableID: dive.StableID, Label: label, Fields: fields})
+		}
+	}
+	for _, dive := range previous.Dives[1:] {
+		if _, ok := latest.stableDiveIDs[dive.StableID]; !ok {
+			label := NewDiveHead(dive, previous.DiveSites[dive.DiveSiteID]).ShortLabel
+			changes.Dives = append(changes.Dives, &DiveChange{Change: ChangeRemoved, StableID: dive.StableID, Label: label})
+		}
+	}
+
+	return changes
+}
+
+// loadChangeLog reads the change sets kept in the cache directory on boot, and the newest cached
+// snapshot, with which the first snapshot is compared unless it is loaded from the cache.
+func loadChangeLog(fromCache bool) {
+	if _control_block.cacheDir == "" {
+		return
+	}
+	if !fromCache {
+		if baseline := loadNewestCachedSnapshot(); baseline != nil {
+			s := &_snapshots
+			s.Lock()
+			s.baseline = baseline
+			s.Unlock()
+		}
+	}
+
+	data, err := os.ReadFile(changeLogPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	var changes []*ChangeSet
+	if err == nil {
+		err = json.Unmarshal(data, &changes)
+	}
+	if err != nil {
+		trace(_error, "change sets of previous runs not loaded: %v", err)
+		return
+	}
+
+	c := &_changelog
+	c.Lock()
+	defer c.Unlock()
+	// change sets recorded before the file was read are newer
+	c.changes = append(c.changes, changes...)
+	if len(c.changes) > maxChangeSets {
+		c.changes = c.changes[:maxChangeSets]
+	}
+	trace(_build, "%d change sets kept from previous runs", len(changes))
+}
+
+func changeLogPath() string {
+	return filepath.Join(_control_block.cacheDir, changeLogFileName)
+}
+
+// storeChangeLog must be called with the change log locked.
+// Failures are only traced, the change sets are then lost with a restart.
+func storeChangeLog(changes []*ChangeSet) {
+	if _control_block.cacheDir == "" {
+		return
+	}
+	data, err := json.Marshal(changes)
+	if err == nil {
+		err = os.MkdirAll(_control_block.cacheDir, 0o755)
+	}
+	if err == nil {
+		err = writeFileAtomically(changeLogPath(), data)
+	}
+	if err != nil {
+		trace(_error, "failed to keep change sets for the next run: %v", err)
+	}
+}
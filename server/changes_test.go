@@ -0,0 +1,173 @@
+package server
+
+import (
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+// changeTestLog returns a snapshot with the sites, trips and dives, linked by their positions.
+func changeTestLog(sites []*DiveSite, trips []*DiveTrip, dives []*Dive) *DiveLog {
+	divelog := &DiveLog{
+		DiveSites:     append([]*DiveSite{nil}, sites...),
+		DiveTrips:     append([]*DiveTrip{nil}, trips...),
+		Dives:         append([]*Dive{nil}, dives...),
+		stableSiteIDs: make(map[string]int),
+		stableTripIDs: make(map[string]int),
+		stableDiveIDs: make(map[string]int),
+	}
+	for i, site := range divelog.DiveSites[1:] {
+		site.ID = i + 1
+		divelog.stableSiteIDs[site.StableID] = site.ID
+	}
+	for i, trip := range divelog.DiveTrips[1:] {
+		trip.ID = i + 1
+		divelog.stableTripIDs[trip.StableID] = trip.ID
+	}
+	for i, dive := range divelog.Dives[1:] {
+		dive.ID = i + 1
+		divelog.stableDiveIDs[dive.StableID] = dive.ID
+	}
+	return divelog
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	previous := changeTestLog(
+		[]*DiveSite{{StableID: "s1", Name: "Vis"}, {StableID: "s2", Name: "Lastovo"}},
+		[]*DiveTrip{{StableID: "t1", Label: "Vis 2023"}, {StableID: "t2", Label: "Lastovo 2023"}},
+		[]*Dive{
+			{StableID: "d1", Number: 1, DiveSiteID: 1, DiveTripID: 1, Notes: "calm"},
+			{StableID: "d2", Number: 2, DiveSiteID: 2, DiveTripID: 2},
+		},
+	)
+
+	tests := []struct {
+		name   string
+		latest *DiveLog
+		want   []string // change, stable ID and changed fields of dives, sites and trips
+	}{
+		{
+			name: "unchanged",
+			latest: changeTestLog(
+				[]*DiveSite{{StableID: "s1", Name: "Vis"}, {StableID: "s2", Name: "Lastovo"}},
+				[]*DiveTrip{{StableID: "t1", Label: "Vis 2023"}, {StableID: "t2", Label: "Lastovo 2023"}},
+				[]*Dive{
+					{StableID: "d1", Number: 1, DiveSiteID: 1, DiveTripID: 1, Notes: "calm"},
+					{StableID: "d2", Number: 2, DiveSiteID: 2, DiveTripID: 2},
+				},
+			),
+		},
+		{
+			name: "reordered in the source file",
+			latest: changeTestLog(
+				[]*DiveSite{{StableID: "s2", Name: "Lastovo"}, {StableID: "s1", Name: "Vis"}},
+				[]*DiveTrip{{StableID: "t2", Label: "Lastovo 2023"}, {StableID: "t1", Label: "Vis 2023"}},
+				[]*Dive{
+					{StableID: "d2", Number: 2, DiveSiteID: 1, DiveTripID: 1},
+					{StableID: "d1", Number: 1, DiveSiteID: 2, DiveTripID: 2, Notes: "calm"},
+				},
+			),
+		},
+		{
+			name: "edited",
+			latest: changeTestLog(
+				[]*DiveSite{{StableID: "s1", Name: "Vis Island", Coordinates: "43.04 16.09"}, {StableID: "s2", Name: "Lastovo"}},
+				[]*DiveTrip{{StableID: "t1", Label: "Vis 2023"}, {StableID: "t2", Label: "Lastovo 2024"}},
+				[]*Dive{
+					{StableID: "d1", Number: 1, DiveSiteID: 1, DiveTripID: 1, Notes: "current"},
+					{StableID: "d2", Number: 2, DiveSiteID: 2, DiveTripID: 2},
+				},
+			),
+			want: []string{
+				"dive edited d1 site notes",
+				"dive edited d2 trip",
+				"site edited s1 name coordinates",
+				"trip edited t2 label",
+			},
+		},
+		{
+			name: "added and removed",
+			latest: changeTestLog(
+				[]*DiveSite{{StableID: "s1", Name: "Vis"}, {StableID: "s3", Name: "Mljet"}},
+				[]*DiveTrip{{StableID: "t1", Label: "Vis 2023"}, {StableID: "t3", Label: "Mljet 2024"}},
+				[]*Dive{
+					{StableID: "d1", Number: 1, DiveSiteID: 1, DiveTripID: 1, Notes: "calm"},
+					{StableID: "d3", Number: 2, DiveSiteID: 2, DiveTripID: 2},
+				},
+			),
+			want: []string{
+				"dive added d3",
+				"dive removed d2",
+				"site added s3",
+				"site removed s2",
+				"trip added t3",
+				"trip removed t2",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes := DiffSnapshots(previous, tt.latest)
+			var got []string
+			describe := func(kind, change, stableID string, fields []*FieldChange) {
+				s := kind + " " + change + " " + stableID
+				for _, field := range fields {
+					s += " " + field.Field
+				}
+				got = append(got, s)
+			}
+			for _, c := range changes.Dives {
+				describe("dive", c.Change, c.StableID, c.Fields)
+			}
+			for _, c := range changes.DiveSites {
+				describe("site", c.Change, c.StableID, c.Fields)
+			}
+			for _, c := range changes.DiveTrips {
+				describe("trip", c.Change, c.StableID, c.Fields)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("changes = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChangeLogKeptAcrossRestart(t *testing.T) {
+	resetSnapshots(t)
+	t.Cleanup(func() { _changelog.changes = nil })
+	_changelog.changes = nil
+
+	site := []*DiveSite{{StableID: "s1", Name: "Vis"}}
+	trip := []*DiveTrip{{StableID: "t1", Label: "Vis 2023"}}
+	first := changeTestLog(site, trip, []*Dive{{StableID: "d1", DiveSiteID: 1, DiveTripID: 1}})
+	publishSnapshot(first)
+	storeSnapshot(first, "first")
+	second := changeTestLog(site, trip, []*Dive{{StableID: "d1", DiveSiteID: 1, DiveTripID: 1}, {StableID: "d2", DiveSiteID: 1, DiveTripID: 1}})
+	publishSnapshot(second)
+	storeSnapshot(second, "second")
+	earlier := time.Now().Add(-time.Minute)
+	if err := os.Chtimes(cachedSnapshotPath("first"), earlier, earlier); err != nil {
+		t.Fatal(err)
+	}
+
+	// restart, without a cached snapshot of the newest data file
+	_snapshots.history = nil
+	_changelog.changes = nil
+	loadChangeLog(false)
+	if got := len(changeSets()); got != 1 {
+		t.Fatalf("%d change sets after a restart, want 1", got)
+	}
+	publishSnapshot(changeTestLog(site, trip, []*Dive{{StableID: "d2", DiveSiteID: 1, DiveTripID: 1}}))
+
+	changes := changeSets()
+	if len(changes) != 2 {
+		t.Fatalf("%d change sets, want the first build compared with the newest cached snapshot", len(changes))
+	}
+	if got := changes[0]; got.FromGeneration != 0 || len(got.Dives) != 1 || got.Dives[0].StableID != "d1" || got.Dives[0].Change != ChangeRemoved {
+		t.Errorf("newest change set = %+v, want d1 removed since the previous run", got)
+	}
+	if got := changes[1]; len(got.Dives) != 1 || got.Dives[0].StableID != "d2" || got.Dives[0].Change != ChangeAdded {
+		t.Errorf("oldest change set = %+v, want d2 added", got)
+	}
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Commands other than serve run the same decoder and builder code as the server,
+// once, on the given data file, without starting the HTTP server or the builder goroutine.
+
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+	exitInvalid = 3
+)
+
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(args []string) int
+}
+
+var _commands []command
+
+func init() {
+	_commands = []command{
+		{"serve", "[flags]", "start the server (the default command)", serve},
+		{"validate", "[-dump] <file>", "check that a data file can be decoded and built", validate},
+		{"export", "[-format csv|json|uddf|subsurface] [-o <output>] <file>", "convert a data file to another format", export},
+		{"stats", "[-json] <file>", "print statistics of a data file", stats},
+		{"query", "[-json] '<filter>' <file>", "list dives matching a filter", query},
+		{"help", "", "print this message", help},
+	}
+}
+
+func Run() {
+	args := os.Args[1:]
+
+	// without a command the server is started, as deployments did before commands were introduced
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		os.Exit(serve(args))
+	}
+
+	for _, cmd := range _commands {
+		if cmd.name == args[0] {
+			if cmd.name != "serve" {
+				// keep the standard output clean for the results
+				_log_console = os.Stderr
+				settings := defaultLogSettings()
+				settings.Level = LogLevelError
+				configureLogging(settings)
+			}
+			os.Exit(cmd.run(args[1:]))
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+	printUsage(os.Stderr)
+	os.Exit(exitUsage)
+}
+
+func help(args []string) int {
+	printUsage(os.Stdout)
+	return exitOK
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "usage: %s <command> [arguments]\n\ncommands:\n", filepath.Base(os.Args[0]))
+	for _, cmd := range _commands {
+		fmt.Fprintf(w, "  %-9s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(w, "\nrun '%s <command> -h' for the arguments of a command\n", filepath.Base(os.Args[0]))
+}
+
+// newCommandFlags returns a flag set for the named command, which prints the usage
+// line of the command, followed by the flags of the command.
+func newCommandFlags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		for _, cmd := range _commands {
+			if cmd.name == name {
+				fmt.Fprintf(fs.Output(), "usage: %s %s %s\n", filepath.Base(os.Args[0]), cmd.name, cmd.args)
+			}
+		}
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseCommandFlags parses the flags of a command, which must be followed by exactly n
+// positional arguments. Returns false if the command should exit with exitUsage.
+func parseCommandFlags(fs *flag.FlagSet, args []string, n int) bool {
+	if err := fs.Parse(args); err != nil {
+		return false
+	}
+	if fs.NArg() != n {
+		fs.Usage()
+		return false
+	}
+	return true
+}
+
+// loadDiveLog builds a dive log from the data file with the builder code.
+// Mappings are read from the directory of the data file, as the server would.
+func loadDiveLog(path string) (*DiveLog, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	_control_block.watchDirectoryPath = filepath.Dir(path)
+	_control_block.autoAwards = true
+	reloadMappings()
+	reloadGearConfig()
+	reloadTrainingCatalogue()
+
+	newDiveLog(path, info.ModTime())
+	if err := buildDatabase(); err != nil {
+		return nil, err
+	}
+	return _divelog, nil
+}
+
+// failLoading reports an error returned by loadDiveLog.
+func failLoading(err error) int {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return fail(exitFailure, "%v", err)
+	}
+	return fail(exitInvalid, "%v", err)
+}
+
+// fail reports an error of a command, and returns the exit code.
+func fail(code int, format string, args ...interface{}) int {
+	fmt.Fprintf(os.Stderr, "%s: %s\n", filepath.Base(os.Args[0]), fmt.Sprintf(format, args...))
+	return code
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Text responses (pages, data and the style sheet) are compressed with gzip or deflate,
+// as negotiated with the Accept-Encoding header. Responses sent by cachedResponse.serve are
+// compressed once per encoding, and the compressed bodies are cached with the response;
+// other responses are compressed by the adapter. Small responses are sent as they are,
+// as compression would not save a round trip.
+
+const (
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate"
+
+	minCompressSize = 1024
+)
+
+// Preferred first, if the client accepts several with the same quality.
+var _encodings = []string{EncodingGzip, EncodingDeflate}
+
+// Other types, e.g. the woff2 font or the favicon, are compressed already, or too small.
+var _compressible_types = []string{"text/html", "text/css", "text/plain", "application/json"}
+
+type encodingKey struct{}
+
+// negotiateEncoding returns the preferred encoding which the client accepts, or "" for none.
+func negotiateEncoding(acceptEncoding string) string {
+	var (
+		best     string
+		bestQ    float64
+		wildcard = -1.0
+		quality  = make(map[string]float64)
+	)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.ToLower(strings.TrimSpace(params)), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if name == "*" {
+			wildcard = q
+		} else {
+			quality[name] = q
+		}
+	}
+	for _, encoding := range _encodings {
+		q, ok := quality[encoding]
+		if !ok {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+func compressibleType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, compressible := range _compressible_types {
+		if mediaType == compressible {
+			return true
+		}
+	}
+	return false
+}
+
+func compress(encoding string, body []byte) []byte {
+	var buffer bytes.Buffer
+	switch encoding {
+	case EncodingGzip:
+		writer := gzip.NewWriter(&buffer)
+		writer.Write(body)
+		writer.Close()
+	case EncodingDeflate:
+		// the deflate content coding is the zlib format
+		writer := zlib.NewWriter(&buffer)
+		writer.Write(body)
+		writer.Close()
+	}
+	return buffer.Bytes()
+}
+
+// encodedETag returns the entity tag of the compressed representation.
+func encodedETag(etag string, encoding string) string {
+	if !strings.HasSuffix(etag, `"`) {
+		return etag
+	}
+	return strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+}
+
+// addVary adds Accept-Encoding to the Vary header, unless it is listed already.
+func addVary(header http.Header) {
+	for _, value := range header.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), "Accept-Encoding") {
+				return
+			}
+		}
+	}
+	header.Add("Vary", "Accept-Encoding")
+}
+
+// requestEncoding returns the encoding negotiated by the Compress adapter for the request, if any.
+func requestEncoding(r *http.Request) string {
+	encoding, _ := r.Context().Value(encodingKey{}).(string)
+	return encoding
+}
+
+// Compress returns an adapter that compresses text responses with the encoding preferred by the client.
+func Compress() Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+			cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+			h.ServeHTTP(cw, r.WithContext(context.WithValue(r.Context(), encodingKey{}, encoding)))
+			cw.finish()
+		})
+	}
+}
+
+// compressWriter buffers successful text responses which are not compressed yet,
+// and passes all other responses through.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	status   int
+	buffer   *bytes.Buffer
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.status != 0 {
+		return
+	}
+	cw.status = status
+
+	header := cw.Header()
+	if !compressibleType(header.Get("Content-Type")) {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	addVary(header)
+	if status != http.StatusOK || cw.encoding == "" || header.Get("Content-Encoding") != "" {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	cw.buffer = &bytes.Buffer{}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.buffer == nil {
+		return cw.ResponseWriter.Write(b)
+	}
+	return cw.buffer.Write(b)
+}
+
+func (cw *compressWriter) finish() {
+	if cw.buffer == nil {
+		return
+	}
+	body := cw.buffer.Bytes()
+	if len(body) >= minCompressSize {
+		header := cw.Header()
+		body = compress(cw.encoding, body)
+		header.Set("Content-Encoding", cw.encoding)
+		header.Del("Content-Length")
+		if etag := header.Get("ETag"); etag != "" {
+			header.Set("ETag", encodedETag(etag, cw.encoding))
+		}
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+	if _, err := cw.ResponseWriter.Write(body); err != nil {
+		trace(_error, "http: send: %v", err)
+	}
+}
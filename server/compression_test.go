@@ -0,0 +1,36 @@
+package server
+
+import "testing"
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"", ""},
+		{"gzip", EncodingGzip},
+		{"deflate", EncodingDeflate},
+		{"br", ""},
+		{"deflate, gzip", EncodingGzip},
+		{"GZIP", EncodingGzip},
+		{"gzip;q=0.5, deflate", EncodingDeflate},
+		{"gzip;Q=0.5, deflate", EncodingDeflate},
+		{"gzip; q=0.8, deflate; q=0.9", EncodingDeflate},
+		{"gzip;q=0", ""},
+		{"gzip;q=0, deflate;q=0", ""},
+		{"gzip;q=0, *", EncodingDeflate},
+		{"*", EncodingGzip},
+		{"*;q=0", ""},
+		{"*;q=0, deflate", EncodingDeflate},
+		{"gzip;q=invalid, deflate", EncodingDeflate},
+		// identity is always sent when nothing else is accepted, even if refused
+		{"identity;q=0", ""},
+		{"identity;q=0, gzip", EncodingGzip},
+		{"identity, gzip;q=0", ""},
+	}
+	for _, tt := range tests {
+		if got := negotiateEncoding(tt.acceptEncoding); got != tt.want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", tt.acceptEncoding, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Successful page and data responses are sent with a strong ETag, derived from the generation
+// of the snapshot and a hash of the body, so that conditional requests are answered with
+// 304 Not Modified. Last-Modified is the time the snapshot was built, which is not sent while
+// rolled back, as an older snapshot is then served. It is informative only: the modification
+// times of snapshots do not grow monotonically, so If-Modified-Since is not answered.
+// Cache-Control lets clients and proxies reuse responses for a while without asking.
+
+const (
+	// pages and data of the served snapshot, which is replaced at most every rebuild interval
+	CacheControlSnapshot = "public, max-age=60"
+	// responses which change without a new snapshot, e.g. the change log
+	CacheControlRevalidate = "no-cache"
+	// the favicon, the font and the style sheet, which only change with a new release
+	CacheControlStatic = "public, max-age=86400"
+	// build status, the admin API and monitoring
+	CacheControlNoStore = "no-store"
+)
+
+// CacheControl returns an adapter that sets the Cache-Control header of all responses.
+func CacheControl(policy string) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", policy)
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// responseRecorder buffers the response of a handler, so that it can be cached,
+// and its validators computed, before it is sent.
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) Header() http.Header { return r.header }
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(b)
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+// recordResponse returns the response of the handler to the request.
+func recordResponse(r *http.Request, handler func(http.ResponseWriter, *http.Request)) *cachedResponse {
+	recorder := &responseRecorder{header: make(http.Header)}
+	handler(recorder, r)
+	response := &cachedResponse{
+		status: recorder.status,
+		header: recorder.header,
+		body:   recorder.body.Bytes(),
+	}
+	if response.status == 0 {
+		response.status = http.StatusOK
+	}
+	return response
+}
+
+// setValidators sets the ETag, Last-Modified and Cache-Control of a successful response.
+// The ETag is prefixed with the generation of the snapshot, if any, and Last-Modified is
+// not set if lastModified is zero.
+func (c *cachedResponse) setValidators(generation uint64, lastModified time.Time, policy string) {
+	if c.status != http.StatusOK {
+		return
+	}
+	sum := sha256.Sum256(c.body)
+	if generation == 0 {
+		c.header.Set("ETag", fmt.Sprintf(`"%x"`, sum[:12]))
+	} else {
+		c.header.Set("ETag", fmt.Sprintf(`"%d-%x"`, generation, sum[:12]))
+	}
+	if !lastModified.IsZero() {
+		c.header.Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+	c.header.Set("Cache-Control", policy)
+}
+
+// serve sends the response, compressed with the encoding negotiated by the Compress adapter.
+// Successful responses are sent by http.ServeContent, which answers If-None-Match and
+// range requests; without a modification time it ignores If-Modified-Since.
+func (c *cachedResponse) serve(w http.ResponseWriter, r *http.Request) {
+	header := w.Header()
+	for name, values := range c.header {
+		header[name] = append([]string(nil), values...)
+	}
+	if c.status == http.StatusOK {
+		body := c.body
+		if compressibleType(c.header.Get("Content-Type")) {
+			addVary(header)
+			if encoding := requestEncoding(r); encoding != "" && len(body) >= minCompressSize {
+				body = c.encode(encoding)
+				header.Set("Content-Encoding", encoding)
+				if etag := header.Get("ETag"); etag != "" {
+					header.Set("ETag", encodedETag(etag, encoding))
+				}
+			}
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(body))
+		return
+	}
+	w.WriteHeader(c.status)
+	if _, err := w.Write(c.body); err != nil {
+		traceContext(r.Context(), _error, "http: send: %v", err)
+	}
+}
+
+// funcWithValidators sends the responses of a handler which does not depend on the snapshot
+// with validators, so that conditional requests are answered.
+func funcWithValidators(policy string, fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		response := recordResponse(r, fn)
+		response.setValidators(0, time.Time{}, policy)
+		response.serve(w, r)
+	}
+}
+
+// encode returns the body compressed with the encoding, which is compressed once per response.
+func (c *cachedResponse) encode(encoding string) []byte {
+	c.encodedLock.Lock()
+	body, ok := c.encoded[encoding]
+	c.encodedLock.Unlock()
+	if ok {
+		return body
+	}
+
+	body = compress(encoding, c.body)
+	c.encodedLock.Lock()
+	if encoded, ok := c.encoded[encoding]; ok {
+		// compressed by a concurrent request meanwhile
+		c.encodedLock.Unlock()
+		return encoded
+	}
+	if c.encoded == nil {
+		c.encoded = make(map[string][]byte)
+	}
+	c.encoded[encoding] = body
+	c.encodedLock.Unlock()
+	growResponse(c, int64(len(body)))
+	return body
+}
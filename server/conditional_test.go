@@ -0,0 +1,56 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCachedResponseValidators(t *testing.T) {
+	builtAt := time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		lastModified time.Time
+		header       map[string]string
+		status       int
+	}{
+		{"no validators", builtAt, nil, http.StatusOK},
+		{"matching ETag", builtAt, map[string]string{"If-None-Match": "etag"}, http.StatusNotModified},
+		{"other ETag", builtAt, map[string]string{"If-None-Match": `"1-other"`}, http.StatusOK},
+		{"If-Modified-Since is ignored", builtAt, map[string]string{"If-Modified-Since": builtAt.Add(time.Hour).Format(http.TimeFormat)}, http.StatusOK},
+		{"rolled back", time.Time{}, nil, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := &cachedResponse{status: http.StatusOK, header: http.Header{"Content-Type": {"image/png"}}, body: []byte("body")}
+			response.setValidators(1, tt.lastModified, CacheControlSnapshot)
+			etag := response.header.Get("ETag")
+
+			r := httptest.NewRequest(http.MethodGet, "/hms/dives", nil)
+			for name, value := range tt.header {
+				if value == "etag" {
+					value = etag
+				}
+				r.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+			response.serve(w, r)
+
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			// not sent with 304 Not Modified when the ETag is
+			want := ""
+			if !tt.lastModified.IsZero() && tt.status == http.StatusOK {
+				want = tt.lastModified.Format(http.TimeFormat)
+			}
+			if got := w.Header().Get("Last-Modified"); got != want {
+				t.Errorf("Last-Modified = %q, want %q", got, want)
+			}
+			if got := w.Header().Get("ETag"); got != etag {
+				t.Errorf("ETag = %q, want %q", got, etag)
+			}
+		})
+	}
+}
@@ -0,0 +1,393 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Settings are read from an optional JSON configuration file, then from DIVELOG_*
+// environment variables, and then from command-line flags, each source overriding
+// the previous one. All problems are collected and reported together.
+
+const (
+	ModeDev           = "dev"
+	ModeProd          = "prod"
+	ModeProdProxyHTTP = "prod-proxy-http"
+
+	defaultDevHost         = "localhost"
+	defaultDevPort         = 8072
+	defaultProdPort        = 443
+	defaultRebuildInterval = time.Minute
+	minRebuildInterval     = time.Second
+	defaultSnapshotHistory = 5
+
+	// in MiB
+	defaultResponseCacheSize = 32
+)
+
+type Config struct {
+	Mode               string   `json:"mode"`
+	Host               string   `json:"host"`
+	Port               int      `json:"port"`
+	WatchDir           string   `json:"watch_dir"`
+	PrivateKeyPath     string   `json:"private_key_path"`
+	CertPath           string   `json:"cert_path"`
+	RebuildInterval    string   `json:"rebuild_interval"`
+	DataFilePrefix     string   `json:"data_file_prefix"`
+	LogLevel           string   `json:"log_level"`
+	LogFormat          string   `json:"log_format"`
+	LogSubsystems      string   `json:"log_subsystems"`
+	LogFile            string   `json:"log_file"`
+	LogMaxSize         int      `json:"log_max_size"`
+	LogMaxFiles        int      `json:"log_max_files"`
+	GearConfigPath     string   `json:"gear_config_path"`
+	TrainingConfigPath string   `json:"training_config_path"`
+	SnapshotHistory    int      `json:"snapshot_history"`
+	CacheDir           string   `json:"cache_dir"`
+	AdminToken         string   `json:"admin_token"`
+	ResponseCacheSize  int      `json:"response_cache_size"`
+	MaxSnapshotAge     string   `json:"max_snapshot_age"`
+	Features           Features `json:"features"`
+}
+
+// Features can be turned off without removing related settings.
+// LocalAPI defaults to true in dev mode only.
+type Features struct {
+	LocalAPI      *bool `json:"local_api"`
+	AutoAwards    bool  `json:"auto_awards"`
+	GearService   bool  `json:"gear_service"`
+	Inotify       bool  `json:"inotify"`
+	SnapshotCache bool  `json:"snapshot_cache"`
+	Metrics       bool  `json:"metrics"`
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Mode:              ModeProd,
+		RebuildInterval:   defaultRebuildInterval.String(),
+		DataFilePrefix:    SubsurfaceDataFilePrefix,
+		LogLevel:          LogLevelInfo,
+		LogFormat:         LogFormatText,
+		LogSubsystems:     LogSubsystemsAll,
+		LogMaxSize:        defaultLogMaxSize,
+		LogMaxFiles:       defaultLogMaxFiles,
+		SnapshotHistory:   defaultSnapshotHistory,
+		ResponseCacheSize: defaultResponseCacheSize,
+		Features: Features{
+			AutoAwards:    true,
+			GearService:   true,
+			Inotify:       true,
+			SnapshotCache: true,
+			Metrics:       true,
+		},
+	}
+}
+
+// setting is a configuration value which can be overridden by an environment variable and a flag.
+type setting struct {
+	flag   string
+	envVar string
+	usage  string
+	isBool bool
+	set    func(c *Config, value string) error
+}
+
+var _settings = []setting{
+	{"mode", "DIVELOG_MODE", "server mode: dev, prod, or prod-proxy-http", false,
+		func(c *Config, v string) error { c.Mode = v; return nil }},
+	{"host", "DIVELOG_IP_HOST", "IP address or host name to listen on", false,
+		func(c *Config, v string) error { c.Host = v; return nil }},
+	{"port", "DIVELOG_PORT", "TCP port to listen on", false,
+		func(c *Config, v string) (err error) { c.Port, err = strconv.Atoi(v); return }},
+	{"watch-dir", "DIVELOG_WATCH_DIR_PATH", "directory containing Subsurface XML files", false,
+		func(c *Config, v string) error { c.WatchDir = v; return nil }},
+	{"private-key", "DIVELOG_PRIVATE_KEY_PATH", "TLS private key (prod mode)", false,
+		func(c *Config, v string) error { c.PrivateKeyPath = v; return nil }},
+	{"cert", "DIVELOG_CERT_PATH", "TLS certificate (prod mode)", false,
+		func(c *Config, v string) error { c.CertPath = v; return nil }},
+	{"rebuild-interval", "DIVELOG_REBUILD_INTERVAL", "interval between checks for newer data files if the watch directory is polled, e.g. 1m", false,
+		func(c *Config, v string) error { c.RebuildInterval = v; return nil }},
+	{"data-file-prefix", "DIVELOG_DATA_FILE_PREFIX", "name prefix of data files in the watch directory", false,
+		func(c *Config, v string) error { c.DataFilePrefix = v; return nil }},
+	{"log-level", "DIVELOG_LOG_LEVEL", "log level: debug, info, or error", false,
+		func(c *Config, v string) error { c.LogLevel = v; return nil }},
+	{"log-format", "DIVELOG_LOG_FORMAT", "log format: text or json", false,
+		func(c *Config, v string) error { c.LogFormat = v; return nil }},
+	{"log-subsystems", "DIVELOG_LOG_SUBSYSTEMS", "traced subsystems: all, or a comma-separated list of control, env, build, link, map and https", false,
+		func(c *Config, v string) error { c.LogSubsystems = v; return nil }},
+	{"log-file", "DIVELOG_LOG_FILE", "log file, rotated by size (default the standard output)", false,
+		func(c *Config, v string) error { c.LogFile = v; return nil }},
+	{"log-max-size", "DIVELOG_LOG_MAX_SIZE", "size of the log file in MiB at which it is rotated, 0 turns rotation off", false,
+		func(c *Config, v string) (err error) { c.LogMaxSize, err = strconv.Atoi(v); return }},
+	{"log-max-files", "DIVELOG_LOG_MAX_FILES", "number of rotated log files kept", false,
+		func(c *Config, v string) (err error) { c.LogMaxFiles, err = strconv.Atoi(v); return }},
+	{"gear-config", "DIVELOG_GEAR_CONFIG_PATH", "serviceable gear configuration", false,
+		func(c *Config, v string) error { c.GearConfigPath = v; return nil }},
+	{"training-config", "DIVELOG_TRAINING_CONFIG_PATH", "training catalogue", false,
+		func(c *Config, v string) error { c.TrainingConfigPath = v; return nil }},
+	{"snapshot-history", "DIVELOG_SNAPSHOT_HISTORY", "number of built snapshots kept for rollback", false,
+		func(c *Config, v string) (err error) { c.SnapshotHistory, err = strconv.Atoi(v); return }},
+	{"cache-dir", "DIVELOG_CACHE_DIR", "directory of cached snapshots (default bluefin in the user cache directory)", false,
+		func(c *Config, v string) error { c.CacheDir = v; return nil }},
+	{"admin-token", "DIVELOG_ADMIN_TOKEN", "bearer token of the admin API, which is disabled without it (prefer the env variable)", false,
+		func(c *Config, v string) error { c.AdminToken = v; return nil }},
+	{"response-cache-size", "DIVELOG_RESPONSE_CACHE_SIZE", "size of the response cache in MiB, 0 turns it off", false,
+		func(c *Config, v string) (err error) { c.ResponseCacheSize, err = strconv.Atoi(v); return }},
+	{"max-snapshot-age", "DIVELOG_MAX_SNAPSHOT_AGE", "age of the served data file after which the server is not ready, e.g. 48h (default no limit)", false,
+		func(c *Config, v string) error { c.MaxSnapshotAge = v; return nil }},
+	{"local-api", "DIVELOG_LOCAL_API", "enable the local API (default true in dev mode)", true,
+		func(c *Config, v string) error {
+			enabled, err := strconv.ParseBool(v)
+			c.Features.LocalAPI = &enabled
+			return err
+		}},
+	{"auto-awards", "DIVELOG_AUTO_AWARDS", "detect milestone awards automatically (default true)", true,
+		func(c *Config, v string) (err error) { c.Features.AutoAwards, err = strconv.ParseBool(v); return }},
+	{"gear-service", "DIVELOG_GEAR_SERVICE", "enable gear service tracking (default true)", true,
+		func(c *Config, v string) (err error) { c.Features.GearService, err = strconv.ParseBool(v); return }},
+	{"inotify", "DIVELOG_INOTIFY", "watch the watch directory with inotify instead of polling it (default true, Linux only)", true,
+		func(c *Config, v string) (err error) { c.Features.Inotify, err = strconv.ParseBool(v); return }},
+	{"snapshot-cache", "DIVELOG_SNAPSHOT_CACHE", "load the snapshot of unchanged data files from the cache on boot (default true)", true,
+		func(c *Config, v string) (err error) { c.Features.SnapshotCache, err = strconv.ParseBool(v); return }},
+	{"metrics", "DIVELOG_METRICS", "serve metrics at /metrics (default true)", true,
+		func(c *Config, v string) (err error) { c.Features.Metrics, err = strconv.ParseBool(v); return }},
+}
+
+const (
+	configFlag   = "config"
+	configEnvVar = "DIVELOG_CONFIG_PATH"
+)
+
+// flagValue records whether a flag was set, so that only flags
+// present on the command line override other sources.
+type flagValue struct {
+	value  string
+	isBool bool
+}
+
+func (f *flagValue) String() string     { return f.value }
+func (f *flagValue) Set(v string) error { f.value = v; return nil }
+func (f *flagValue) IsBoolFlag() bool   { return f.isBool }
+
+// LoadConfig merges all configuration sources, and validates the result.
+func LoadConfig(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("bluefin", flag.ContinueOnError)
+	configPath := fs.String(configFlag, "", "JSON configuration file (env "+configEnvVar+")")
+	flagValues := make(map[string]*flagValue, len(_settings))
+	for _, s := range _settings {
+		flagValues[s.flag] = &flagValue{isBool: s.isBool}
+		fs.Var(flagValues[s.flag], s.flag, s.usage+" (env "+s.envVar+")")
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	var (
+		config   = defaultConfig()
+		problems []error
+	)
+
+	if *configPath == "" {
+		*configPath = os.Getenv(configEnvVar)
+		trace(_env, "%s = %q", configEnvVar, *configPath)
+	}
+	if *configPath != "" {
+		if err := readConfigFile(*configPath, config); err != nil {
+			problems = append(problems, err)
+		} else {
+			trace(_control, "configuration read from %s", *configPath)
+		}
+	}
+
+	for _, s := range _settings {
+		// env files export variables they do not set as empty, e.g. deploy/env.sh
+		value := os.Getenv(s.envVar)
+		if value == "" {
+			continue
+		}
+		trace(_env, "%s = %q", s.envVar, value)
+		if err := s.set(config, value); err != nil {
+			problems = append(problems, fmt.Errorf("%s: invalid value %q", s.envVar, value))
+		}
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range _settings {
+			if s.flag == f.Name {
+				if err := s.set(config, flagValues[s.flag].value); err != nil {
+					problems = append(problems, fmt.Errorf("-%s: invalid value %q", s.flag, flagValues[s.flag].value))
+				}
+			}
+		}
+	})
+
+	problems = append(problems, config.validate()...)
+	if len(problems) > 0 {
+		return nil, errors.Join(problems...)
+	}
+	return config, nil
+}
+
+func readConfigFile(path string, config *Config) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		return fmt.Errorf("failed to parse configuration %s: %v", path, err)
+	}
+	return nil
+}
+
+// validate fills in defaults which depend on the mode, and reports all problems.
+func (c *Config) validate() (problems []error) {
+	switch c.Mode {
+	case ModeDev:
+		if c.Host == "" {
+			c.Host = defaultDevHost
+		}
+		if c.Port == 0 {
+			c.Port = defaultDevPort
+		}
+	case ModeProd, ModeProdProxyHTTP:
+		if c.Host == "" {
+			problems = append(problems, fmt.Errorf("host is required in mode %q", c.Mode))
+		}
+		if c.Port == 0 {
+			c.Port = defaultProdPort
+		}
+	default:
+		problems = append(problems, fmt.Errorf("mode %q is invalid, expected %q, %q or %q", c.Mode, ModeDev, ModeProd, ModeProdProxyHTTP))
+	}
+	if c.Features.LocalAPI == nil {
+		localAPI := c.Mode == ModeDev
+		c.Features.LocalAPI = &localAPI
+	}
+
+	if c.Port < 1 || c.Port > 65535 {
+		problems = append(problems, fmt.Errorf("port %d is not a valid TCP port number", c.Port))
+	}
+
+	if c.WatchDir == "" {
+		problems = append(problems, errors.New("watch directory is required"))
+	} else if info, err := os.Stat(c.WatchDir); err != nil {
+		problems = append(problems, fmt.Errorf("watch directory: %v", err))
+	} else if !info.IsDir() {
+		problems = append(problems, fmt.Errorf("watch directory %s is not a directory", c.WatchDir))
+	}
+
+	if c.Mode == ModeProd {
+		if c.PrivateKeyPath == "" {
+			problems = append(problems, fmt.Errorf("private key is required in mode %q", c.Mode))
+		}
+		if c.CertPath == "" {
+			problems = append(problems, fmt.Errorf("certificate is required in mode %q", c.Mode))
+		}
+	}
+	for _, path := range []string{c.PrivateKeyPath, c.CertPath, c.GearConfigPath, c.TrainingConfigPath} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			problems = append(problems, err)
+		}
+	}
+
+	if interval, err := time.ParseDuration(c.RebuildInterval); err != nil {
+		problems = append(problems, fmt.Errorf("rebuild interval %q is invalid, expected a duration such as 1m or 30s", c.RebuildInterval))
+	} else if interval < minRebuildInterval {
+		problems = append(problems, fmt.Errorf("rebuild interval %s is shorter than %s", interval, minRebuildInterval))
+	}
+
+	if c.DataFilePrefix == "" || strings.ContainsAny(c.DataFilePrefix, `/\`) {
+		problems = append(problems, fmt.Errorf("data file prefix %q must be non-empty and must not contain path separators", c.DataFilePrefix))
+	}
+
+	if c.Features.SnapshotCache && c.CacheDir == "" {
+		// without a user cache directory (e.g. without $HOME), the cache is turned off
+		if dir, err := os.UserCacheDir(); err == nil {
+			c.CacheDir = filepath.Join(dir, "bluefin")
+		} else {
+			c.Features.SnapshotCache = false
+		}
+	}
+
+	if c.SnapshotHistory < 1 {
+		problems = append(problems, fmt.Errorf("snapshot history %d must be at least 1", c.SnapshotHistory))
+	}
+
+	if c.ResponseCacheSize < 0 {
+		problems = append(problems, fmt.Errorf("response cache size %d must not be negative", c.ResponseCacheSize))
+	}
+
+	if c.MaxSnapshotAge != "" {
+		if age, err := time.ParseDuration(c.MaxSnapshotAge); err != nil || age <= 0 {
+			problems = append(problems, fmt.Errorf("max snapshot age %q is invalid, expected a positive duration such as 48h", c.MaxSnapshotAge))
+		}
+	}
+
+	settings := c.logSettings()
+	problems = append(problems, settings.validate()...)
+
+	return
+}
+
+func (c *Config) logSettings() LogSettings {
+	return LogSettings{
+		Level:      c.LogLevel,
+		Format:     c.LogFormat,
+		Subsystems: c.LogSubsystems,
+		File:       c.LogFile,
+		MaxSize:    c.LogMaxSize,
+		MaxFiles:   c.LogMaxFiles,
+	}
+}
+
+// apply must be called after a successful validation.
+func (c *Config) apply(cb *control) {
+	cb.endpoint = net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+	cb.encryptedTraffic = c.Mode == ModeProd
+	cb.encryptionKeyPath = c.PrivateKeyPath
+	cb.publicCertPath = c.CertPath
+	cb.watchDirectoryPath = c.WatchDir
+	cb.rebuildInterval, _ = time.ParseDuration(c.RebuildInterval)
+	cb.dataFilePrefix = c.DataFilePrefix
+	cb.localAPI = *c.Features.LocalAPI
+	cb.autoAwards = c.Features.AutoAwards
+	cb.inotify = c.Features.Inotify
+	if c.Features.GearService {
+		cb.gearConfigPath = c.GearConfigPath
+	}
+	cb.trainingConfigPath = c.TrainingConfigPath
+	cb.snapshotHistory = c.SnapshotHistory
+	cb.adminToken = c.AdminToken
+	cb.responseCacheSize = int64(c.ResponseCacheSize) << 20
+	if c.MaxSnapshotAge != "" {
+		cb.maxSnapshotAge, _ = time.ParseDuration(c.MaxSnapshotAge)
+	}
+	cb.metrics = c.Features.Metrics
+	if c.Features.SnapshotCache {
+		cb.cacheDir = c.CacheDir
+	}
+
+	scheme := "http"
+	if cb.encryptedTraffic {
+		scheme = "https"
+	}
+	trace(_control, "in mode %q (%s): endpoint will be %s://%s", c.Mode, strings.ToUpper(scheme), scheme, cb.endpoint)
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// configTestFile writes the configuration file into dir, which is also the watch directory.
+func configTestFile(t *testing.T, dir string, json string) string {
+	t.Helper()
+	path := filepath.Join(dir, "bluefin.json")
+	json = strings.ReplaceAll(json, "$WATCH_DIR", filepath.ToSlash(dir))
+	if err := os.WriteFile(path, []byte(json), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// clearConfigEnv makes sure that the environment of the test run is not picked up.
+func clearConfigEnv(t *testing.T) {
+	t.Helper()
+	t.Setenv(configEnvVar, "")
+	os.Unsetenv(configEnvVar)
+	for _, s := range _settings {
+		t.Setenv(s.envVar, "")
+		os.Unsetenv(s.envVar)
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	path := configTestFile(t, dir, `{
+		"mode": "dev",
+		"watch_dir": "$WATCH_DIR",
+		"port": 1000,
+		"rebuild_interval": "10s",
+		"data_file_prefix": "file-",
+		"snapshot_history": 7,
+		"features": {"auto_awards": false}
+	}`)
+
+	tests := []struct {
+		name  string
+		env   map[string]string
+		args  []string
+		check func(t *testing.T, c *Config)
+	}{
+		{
+			name: "file",
+			args: []string{"-config", path},
+			check: func(t *testing.T, c *Config) {
+				if c.Port != 1000 || c.RebuildInterval != "10s" || c.DataFilePrefix != "file-" || c.Features.AutoAwards {
+					t.Errorf("config = %+v, want the values of the file", c)
+				}
+				if c.LogLevel != LogLevelInfo || !c.Features.GearService {
+					t.Errorf("config = %+v, want defaults for values missing from the file", c)
+				}
+			},
+		},
+		{
+			name: "file from env",
+			env:  map[string]string{configEnvVar: path},
+			check: func(t *testing.T, c *Config) {
+				if c.Port != 1000 {
+					t.Errorf("port = %d, want the port of the file", c.Port)
+				}
+			},
+		},
+		{
+			name: "env overrides file",
+			env:  map[string]string{"DIVELOG_PORT": "2000", "DIVELOG_DATA_FILE_PREFIX": "env-", "DIVELOG_AUTO_AWARDS": "true"},
+			args: []string{"-config", path},
+			check: func(t *testing.T, c *Config) {
+				if c.Port != 2000 || c.DataFilePrefix != "env-" || !c.Features.AutoAwards {
+					t.Errorf("config = %+v, want the values of the env", c)
+				}
+				if c.RebuildInterval != "10s" || c.SnapshotHistory != 7 {
+					t.Errorf("config = %+v, want the values of the file not set by the env", c)
+				}
+			},
+		},
+		{
+			name: "empty env is unset",
+			env:  map[string]string{"DIVELOG_MODE": "", "DIVELOG_PORT": "", "DIVELOG_DATA_FILE_PREFIX": ""},
+			args: []string{"-config", path},
+			check: func(t *testing.T, c *Config) {
+				if c.Mode != "dev" || c.Port != 1000 || c.DataFilePrefix != "file-" {
+					t.Errorf("config = %+v, want the values of the file", c)
+				}
+			},
+		},
+		{
+			name: "flags override env and file",
+			env:  map[string]string{"DIVELOG_PORT": "2000", "DIVELOG_DATA_FILE_PREFIX": "env-"},
+			args: []string{"-config", path, "-port", "3000", "-auto-awards", "-snapshot-history=2"},
+			check: func(t *testing.T, c *Config) {
+				if c.Port != 3000 || !c.Features.AutoAwards || c.SnapshotHistory != 2 {
+					t.Errorf("config = %+v, want the values of the flags", c)
+				}
+				if c.DataFilePrefix != "env-" || c.RebuildInterval != "10s" {
+					t.Errorf("config = %+v, want the values of the env and the file not set by flags", c)
+				}
+			},
+		},
+		{
+			name: "mode defaults",
+			args: []string{"-mode", "dev", "-watch-dir", dir},
+			check: func(t *testing.T, c *Config) {
+				if c.Host != defaultDevHost || c.Port != defaultDevPort || !*c.Features.LocalAPI {
+					t.Errorf("config = %+v, want the defaults of the dev mode", c)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearConfigEnv(t)
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			config, err := LoadConfig(tt.args)
+			if err != nil {
+				t.Fatalf("LoadConfig(%q): %v", tt.args, err)
+			}
+			tt.check(t, config)
+		})
+	}
+}
+
+func TestLoadConfigProblems(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name     string
+		json     string
+		env      map[string]string
+		args     []string
+		problems []string // substrings of the error
+	}{
+		{
+			name: "all sources reported together",
+			json: `{"mode": "staging", "watch_dir": "$WATCH_DIR", "rebuild_interval": "soon"}`,
+			env:  map[string]string{"DIVELOG_PORT": "http"},
+			args: []string{"-snapshot-history", "0", "-auto-awards=maybe"},
+			problems: []string{
+				`mode "staging" is invalid`,
+				`rebuild interval "soon" is invalid`,
+				`DIVELOG_PORT: invalid value "http"`,
+				`snapshot history 0 must be at least 1`,
+				`-auto-awards: invalid value "maybe"`,
+			},
+		},
+		{
+			name:     "unknown field",
+			json:     `{"mode": "dev", "watch_dir": "$WATCH_DIR", "prot": 80}`,
+			problems: []string{`unknown field "prot"`},
+		},
+		{
+			name:     "prod mode requirements",
+			json:     `{"mode": "prod", "watch_dir": "$WATCH_DIR"}`,
+			problems: []string{`host is required in mode "prod"`, "private key is required", "certificate is required"},
+		},
+		{
+			name:     "missing watch directory",
+			json:     `{"mode": "dev"}`,
+			problems: []string{"watch directory is required"},
+		},
+		{
+			name:     "unexpected arguments",
+			json:     `{"mode": "dev", "watch_dir": "$WATCH_DIR"}`,
+			args:     []string{"serve"},
+			problems: []string{"unexpected arguments: serve"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearConfigEnv(t)
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			args := append([]string{"-config", configTestFile(t, dir, tt.json)}, tt.args...)
+			config, err := LoadConfig(args)
+			if err == nil {
+				t.Fatalf("LoadConfig(%q) = %+v, want problems %q", args, config, tt.problems)
+			}
+			for _, problem := range tt.problems {
+				if !strings.Contains(err.Error(), problem) {
+					t.Errorf("error %q does not report %q", err, problem)
+				}
+			}
+		})
+	}
+}
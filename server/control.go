@@ -10,6 +10,7 @@ import (
 	"os"
 	"os/signal"
 	"sync"
+	"time"
 )
 
 type control struct {
@@ -25,8 +26,20 @@ type control struct {
 	encryptionKeyPath  string
 	publicCertPath     string
 	watchDirectoryPath string
+	gearConfigPath     string
+	trainingConfigPath string
+	dataFilePrefix     string
+	rebuildInterval    time.Duration
+	snapshotHistory    int
+	adminToken         string
+	cacheDir           string
+	responseCacheSize  int64
+	maxSnapshotAge     time.Duration
 	encryptedTraffic   bool
 	localAPI           bool
+	autoAwards         bool
+	inotify            bool
+	metrics            bool
 }
 
 func (c *control) boot() {
@@ -12,4 +12,5 @@ func acquireDataAccess() *DiveLog {
 
 func swapLatestData(latest *DiveLog) {
 	_divelog_latest.Store(latest)
+	resetResponseCache(latest.Metadata.generation)
 }
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Data files can be replaced while the server is running, e.g. by rsync from deploy/sync.sh,
+// so a data file is read only once it stopped changing, and it is read whole, to make sure
+// that it did not change while it was being read. Files written under a temporary name and
+// then renamed are picked up only once renamed. If there is a checksum sidecar file next to
+// the data file ({name}.sha256, in the sha256sum format), the data file must match it.
+
+const (
+	// A data file is considered completely written once its size and
+	// modification time have not changed for this long.
+	dataFileSettleWindow = 2 * time.Second
+	dataFileSettleChecks = 5
+
+	ChecksumFileSuffix = ".sha256"
+)
+
+var _temporary_file_suffixes = []string{".tmp", ".part", ".partial", ChecksumFileSuffix}
+
+type dataFile struct {
+	path    string
+	modTime time.Time
+}
+
+// rejectedDataFile is a data file which could not be built.
+// The hash is empty if the data file could not be read.
+type rejectedDataFile struct {
+	*dataFile
+	hash string
+	err  error
+}
+
+// Data files which could not be decoded, by content hash, so that they are not decoded
+// again with every builder iteration. Only the builder goroutine accesses this map.
+// There is at most one entry per path, and entries of data files which are gone are pruned.
+var _known_bad_data_files = make(map[string]*knownBadDataFile)
+
+type knownBadDataFile struct {
+	path string
+	err  error
+}
+
+// rememberBadDataFile replaces any content previously remembered for the path.
+func rememberBadDataFile(path string, hash string, err error) {
+	for known, bad := range _known_bad_data_files {
+		if bad.path == path {
+			delete(_known_bad_data_files, known)
+		}
+	}
+	_known_bad_data_files[hash] = &knownBadDataFile{path: path, err: err}
+}
+
+// pruneKnownBadDataFiles forgets data files which are not among the candidates any more.
+func pruneKnownBadDataFiles(candidates []*dataFile) {
+	paths := make(map[string]bool, len(candidates))
+	for _, candidate := range candidates {
+		paths[candidate.path] = true
+	}
+	for hash, bad := range _known_bad_data_files {
+		if !paths[bad.path] {
+			delete(_known_bad_data_files, hash)
+			trace(_build, "forgot invalid data file %s, it is no longer in the watch directory", bad.path)
+		}
+	}
+}
+
+var (
+	errDataFileNotSettled = errors.New("data file is still being written")
+	errDataFileChanged    = errors.New("data file changed while it was being read")
+)
+
+// isDataFileName reports whether the file is a data file; hidden files (e.g. rsync
+// temporary files), temporary files and checksum sidecar files are not data files.
+func isDataFileName(name string) bool {
+	if !strings.HasPrefix(name, _control_block.dataFilePrefix) || strings.HasPrefix(name, ".") {
+		return false
+	}
+	for _, suffix := range _temporary_file_suffixes {
+		if strings.HasSuffix(name, suffix) {
+			return false
+		}
+	}
+	return true
+}
+
+// waitUntilSettled returns once the size and modification time of the file have not changed
+// for the settle window, checking at most the given number of times. Files last modified
+// before the settle window are not waited for, and with no checks, nothing is waited for.
+func waitUntilSettled(path string, checks int) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; ; i++ {
+		unchanged := time.Since(info.ModTime())
+		if unchanged >= dataFileSettleWindow {
+			return nil
+		}
+		if i == checks {
+			return fmt.Errorf("%w: %s", errDataFileNotSettled, path)
+		}
+		trace(_build, "waiting for data file %s to settle", path)
+		time.Sleep(dataFileSettleWindow - unchanged)
+
+		latest, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if latest.Size() == info.Size() && latest.ModTime().Equal(info.ModTime()) {
+			return nil
+		}
+		info = latest
+	}
+}
+
+// readDataFile reads the whole file, and verifies it against its checksum sidecar file, if any.
+func readDataFile(path string) ([]byte, error) {
+	before, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file %s: %v", path, err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file %s: %v", path, err)
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file %s: %v", path, err)
+	}
+	if after.Size() != int64(len(data)) || !after.ModTime().Equal(before.ModTime()) {
+		return nil, fmt.Errorf("%w: %s", errDataFileChanged, path)
+	}
+
+	if err := verifyChecksum(path, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func verifyChecksum(path string, data []byte) error {
+	sidecar, err := os.ReadFile(path + ChecksumFileSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read checksum file: %v", err)
+	}
+
+	fields := strings.Fields(string(sidecar))
+	if len(fields) == 0 {
+		return fmt.Errorf("checksum file %s is empty", path+ChecksumFileSuffix)
+	}
+	if actual := contentHash(data); !strings.EqualFold(fields[0], actual) {
+		return fmt.Errorf("checksum of %s does not match %s, the file may be partially written", path, path+ChecksumFileSuffix)
+	}
+	trace(_build, "data file %s matches its checksum file", path)
+	return nil
+}
+
+// contentHash is the SHA-256 hash of the data, in the sha256sum format.
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWaitUntilSettled(t *testing.T) {
+	tests := []struct {
+		name   string
+		age    time.Duration
+		checks int
+		want   error
+	}{
+		{"settled, not waited for", time.Minute, dataFileSettleChecks, nil},
+		{"settled, no checks", time.Minute, 0, nil},
+		{"recent, no checks", 0, 0, errDataFileNotSettled},
+		{"recent, settles", 0, 1, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "subsurfacedata.xml")
+			if err := os.WriteFile(path, []byte("<divelog/>"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			modTime := time.Now().Add(-tt.age)
+			if err := os.Chtimes(path, modTime, modTime); err != nil {
+				t.Fatal(err)
+			}
+			if err := waitUntilSettled(path, tt.checks); !errors.Is(err, tt.want) {
+				t.Errorf("waitUntilSettled(%d checks) = %v, want %v", tt.checks, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestKnownBadDataFiles(t *testing.T) {
+	defer func() { _known_bad_data_files = make(map[string]*knownBadDataFile) }()
+	_known_bad_data_files = make(map[string]*knownBadDataFile)
+	bad := errors.New("bad")
+
+	rememberBadDataFile("a.xml", "1", bad)
+	rememberBadDataFile("b.xml", "2", bad)
+	rememberBadDataFile("a.xml", "3", bad)
+	if _, ok := _known_bad_data_files["1"]; ok || len(_known_bad_data_files) != 2 {
+		t.Errorf("after a.xml changed, known bad data files = %v, want hashes 2 and 3", _known_bad_data_files)
+	}
+
+	pruneKnownBadDataFiles([]*dataFile{{path: "b.xml"}, {path: "c.xml"}})
+	if _, ok := _known_bad_data_files["2"]; !ok || len(_known_bad_data_files) != 1 {
+		t.Errorf("after a.xml was removed, known bad data files = %v, want hash 2", _known_bad_data_files)
+	}
+}
@@ -2,6 +2,9 @@ package server
 
 import (
 	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 
@@ -14,6 +17,21 @@ type DiveLog struct {
 	DiveTrips        []*DiveTrip
 	Dives            []*Dive
 	sourceToSystemID map[string]int
+
+	// Stable IDs survive rebuilds from edited data files,
+	// unlike system IDs which are assigned in file order.
+	stableSiteIDs map[string]int
+	stableTripIDs map[string]int
+	stableDiveIDs map[string]int
+
+	mappings          *Mappings
+	gearConfig        *GearConfig
+	trainingCatalogue *TrainingCatalogue
+
+	// data problems found while building
+	issues []*ValidationIssue
+
+	index *diveIndex
 }
 
 type DiveLogMetadata struct {
@@ -23,12 +41,17 @@ type DiveLogMetadata struct {
 	ModificationTime string `json:"modification_time"`
 	Units            string `json:"units"`
 
-	modTime time.Time
+	generation    uint64
+	modTime       time.Time
+	sourceHash    string
+	builtAt       time.Time
+	buildDuration time.Duration
 }
 
 type DiveSite struct {
-	ID   int    `json:"id"`
-	Name string `json:"name"`
+	ID       int    `json:"id"`
+	StableID string `json:"stable_id"`
+	Name     string `json:"name"`
 
 	Coordinates string   `json:"coordinates,omitempty"`
 	Description string   `json:"description,omitempty"`
@@ -39,15 +62,17 @@ type DiveSite struct {
 }
 
 type DiveTrip struct {
-	ID    int    `json:"id"`
-	Label string `json:"label"`
+	ID       int    `json:"id"`
+	StableID string `json:"stable_id"`
+	Label    string `json:"label"`
 }
 
 type Dive struct {
-	ID         int `json:"id"`
-	Number     int `json:"number"`
-	DiveSiteID int `json:"dive_site_id"`
-	DiveTripID int `json:"dive_trip_id"`
+	ID         int    `json:"id"`
+	StableID   string `json:"stable_id"`
+	Number     int    `json:"number"`
+	DiveSiteID int    `json:"dive_site_id"`
+	DiveTripID int    `json:"dive_trip_id"`
 
 	Duration        string   `json:"duration,omitempty"`
 	Rating5         int      `json:"rating5,omitempty"`
@@ -75,12 +100,24 @@ type Dive struct {
 	Award           string   `json:"award,omitempty"`
 
 	datetime time.Time
+	duration time.Duration
+	depthMax float64
+	cylinder string
+	gear     []string
+	certs    []certTag
+	courses  []string
+
+	manualAwards []string
+	autoAwards   []string
 }
 
 func (s *DiveSite) String() string {
 	return fmt.Sprintf("S%d:[%s]", s.ID, s.Name)
 }
 
+// LogValue formats the dive site only if it is traced.
+func (s *DiveSite) LogValue() slog.Value { return slog.StringValue(s.String()) }
+
 func (s *DiveSite) ShortName() string {
 	return strings.TrimSpace(strings.Split(s.Name, ",")[0])
 }
@@ -94,6 +131,9 @@ func (t *DiveTrip) String() string {
 	return fmt.Sprintf("T%d:[%s]", t.ID, t.Label)
 }
 
+// LogValue formats the dive trip only if it is traced.
+func (t *DiveTrip) LogValue() slog.Value { return slog.StringValue(t.String()) }
+
 func (d *Dive) Ago() string {
 	years, months, days := utils.DurationToYMD(d.datetime, time.Now().UTC())
 	return fmt.Sprintf("%dy %dm %dd", years, months, days)
@@ -103,7 +143,10 @@ func (d *Dive) String() string {
 	return fmt.Sprintf("D%d:[%s]", d.ID, d.datetime.Format(time.DateOnly))
 }
 
-func (d *Dive) Normalize() {
+// LogValue formats the dive only if it is traced.
+func (d *Dive) LogValue() slog.Value { return slog.StringValue(d.String()) }
+
+func (d *Dive) Normalize(mappings *Mappings) {
 	if strings.HasPrefix(d.Salinity, "1000") {
 		d.Salinity = "fresh water"
 	} else if strings.HasPrefix(d.Salinity, "1030") {
@@ -118,13 +161,41 @@ func (d *Dive) Normalize() {
 		d.Gas = "nitrox " + d.Gas
 	}
 
-	if cylType, ok := CylinderTypeMappings[d.CylType]; ok {
+	if cylType, ok := mappings.CylinderTypes[d.CylType]; ok {
 		d.CylType = cylType
 	} else {
 		d.CylType = "unrecognized"
 	}
 }
 
+// Buddies returns the normalized names of all buddies listed for the dive.
+func (d *Dive) Buddies() []string {
+	return utils.SplitNames(d.Buddy)
+}
+
+// Operators returns the normalized names of all dive operators
+// and dive masters listed for the dive.
+func (d *Dive) Operators() []string {
+	return utils.SplitNames(d.OperatorDM)
+}
+
+// IsNightDive reports whether the dive is tagged as a night dive,
+// or started between 19:00 and 05:00 local time.
+func (d *Dive) IsNightDive() bool {
+	for _, tag := range d.Tags {
+		if strings.EqualFold(tag, "night") || strings.EqualFold(tag, "night dive") {
+			return true
+		}
+	}
+	hour := d.datetime.Hour()
+	return hour >= 19 || hour < 5
+}
+
+// UsedGear reports whether the dive is tagged with the serviceable item with the given ID.
+func (d *Dive) UsedGear(id string) bool {
+	return slices.Contains(d.gear, id)
+}
+
 func (d *Dive) IsTaggedWith(tag string) bool {
 	if tag == "" {
 		return true
@@ -137,14 +208,22 @@ func (d *Dive) IsTaggedWith(tag string) bool {
 	return false
 }
 
-func (d *Dive) ProcessSpecialTags(specialTags []string) {
+func (d *Dive) ProcessSpecialTags(specialTags []string, mappings *Mappings) {
 	for _, tag := range specialTags {
 		key, value := utils.ParseSpecialTag(tag)
 		switch key {
 		case "award":
-			if mappedAward, ok := AwardMappings[value]; ok {
-				d.Award = mappedAward
+			if _, ok := mappings.Awards[value]; ok {
+				d.manualAwards = append(d.manualAwards, value)
+			}
+		case "gear":
+			d.gear = append(d.gear, utils.Slug(value))
+		case "cert":
+			if agency, level, ok := strings.Cut(value, "_"); ok && agency != "" && level != "" {
+				d.certs = append(d.certs, certTag{utils.Slug(agency), utils.Slug(level)})
 			}
+		case "course":
+			d.courses = append(d.courses, utils.Slug(value))
 		}
 	}
 }
@@ -156,3 +235,62 @@ func (dl *DiveLog) LargestDiveID() int {
 func (dl *DiveLog) LargestSiteID() int {
 	return len(dl.DiveSites) - 1
 }
+
+func (dl *DiveLog) LargestTripID() int {
+	return len(dl.DiveTrips) - 1
+}
+
+// LoggedDives returns the number of dives in the log, or the largest dive number
+// if the log does not start with the first dive of the diver's career.
+func (dl *DiveLog) LoggedDives() int {
+	logged := len(dl.Dives) - 1
+	for _, dive := range dl.Dives[1:] {
+		logged = max(logged, dive.Number)
+	}
+	return logged
+}
+
+// stableIDClaim is an object claiming the stable ID derived from its source data.
+type stableIDClaim struct {
+	id       int    // system ID
+	stableID string // derived from the source data
+	order    string // source data which orders objects claiming the same stable ID
+}
+
+// assignStableIDs registers the claimed stable IDs in index, and returns them by system ID.
+// In the unlikely case of a collision, numeric suffixes are added in the order of the source
+// data of the objects, not in file order, so that editing one object cannot hand its stable ID
+// to another.
+func assignStableIDs(index map[string]int, claims []stableIDClaim) []string {
+	sort.Slice(claims, func(i, j int) bool {
+		a, b := claims[i], claims[j]
+		if a.stableID != b.stableID {
+			return a.stableID < b.stableID
+		}
+		if a.order != b.order {
+			return a.order < b.order
+		}
+		// the same source data, so either may keep the stable ID
+		return a.id < b.id
+	})
+	stableIDs := make([]string, len(claims)+1)
+	for _, claim := range claims {
+		stableIDs[claim.id] = assignStableID(index, claim.stableID, claim.id)
+	}
+	return stableIDs
+}
+
+// assignStableID registers stableID in index for the object with the given
+// system ID. In the unlikely case of a collision, a numeric suffix is added
+// so that the stable ID remains unique within the snapshot.
+func assignStableID(index map[string]int, stableID string, id int) string {
+	unique := stableID
+	for n := 2; ; n++ {
+		if _, taken := index[unique]; !taken {
+			break
+		}
+		unique = fmt.Sprintf("%s-%d", stableID, n)
+	}
+	index[unique] = id
+	return unique
+}
@@ -0,0 +1,321 @@
+package server
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"src.acicovic.me/divelog/server/utils"
+	"src.acicovic.me/divelog/subsurface"
+)
+
+const (
+	ExportCSV        = "csv"
+	ExportJSON       = "json"
+	ExportUDDF       = "uddf"
+	ExportSubsurface = "subsurface"
+)
+
+func export(args []string) int {
+	fs := newCommandFlags("export")
+	format := fs.String("format", ExportJSON, "output format: csv, json, uddf, or subsurface")
+	output := fs.String("o", "", "output file (default standard output)")
+	if !parseCommandFlags(fs, args, 1) {
+		return exitUsage
+	}
+	path := fs.Arg(0)
+
+	var write func(w io.Writer) error
+	switch *format {
+	case ExportSubsurface:
+		// normalization in the builder is lossy, so the decoded file is encoded again instead
+		file, err := os.Open(path)
+		if err != nil {
+			return fail(exitFailure, "failed to open file: %v", err)
+		}
+		defer file.Close()
+
+		recorder := &subsurface.Recorder{}
+		if err := subsurface.DecodeSubsurfaceDatabase(file, recorder); err != nil {
+			return fail(exitInvalid, "failed to decode database in %s: %v", path, err)
+		}
+		write = recorder.Encode
+	case ExportCSV, ExportJSON, ExportUDDF:
+		divelog, err := loadDiveLog(path)
+		if err != nil {
+			return failLoading(err)
+		}
+		write = func(w io.Writer) error {
+			switch *format {
+			case ExportCSV:
+				return exportCSV(w, divelog)
+			case ExportUDDF:
+				return exportUDDF(w, divelog)
+			default:
+				return exportJSON(w, divelog)
+			}
+		}
+	default:
+		return fail(exitUsage, "unsupported export format %q", *format)
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fail(exitFailure, "%v", err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	buffered := bufio.NewWriter(out)
+	if err := write(buffered); err != nil {
+		return fail(exitFailure, "export failed: %v", err)
+	}
+	if err := buffered.Flush(); err != nil {
+		return fail(exitFailure, "export failed: %v", err)
+	}
+	return exitOK
+}
+
+// Export is the JSON export format; unlike the local API, it does not include
+// the unused first element of each slice.
+type Export struct {
+	Metadata  DiveLogMetadata `json:"metadata"`
+	DiveSites []*DiveSite     `json:"dive_sites"`
+	DiveTrips []*DiveTrip     `json:"dive_trips"`
+	Dives     []*Dive         `json:"dives"`
+}
+
+func exportJSON(w io.Writer, divelog *DiveLog) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(&Export{
+		Metadata:  divelog.Metadata,
+		DiveSites: divelog.DiveSites[1:],
+		DiveTrips: divelog.DiveTrips[1:],
+		Dives:     divelog.Dives[1:],
+	})
+}
+
+var _csv_header = []string{
+	"number", "stable_id", "date_time_in", "duration", "site", "region", "coordinates", "trip",
+	"depth_max", "depth_mean", "temp_water_min", "temp_air", "salinity", "buddy", "operator_dm",
+	"suit", "cylinder", "gas", "start_pressure", "end_pressure", "weights", "weights_type",
+	"dc_model", "rating5", "visibility5", "tags", "award", "notes",
+}
+
+// exportCSV writes one row per dive, in the order of the dive log.
+func exportCSV(w io.Writer, divelog *DiveLog) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(_csv_header); err != nil {
+		return err
+	}
+	for _, dive := range divelog.Dives[1:] {
+		site := divelog.DiveSites[dive.DiveSiteID]
+		trip := divelog.DiveTrips[dive.DiveTripID]
+		record := []string{
+			strconv.Itoa(dive.Number), dive.StableID, dive.DateTimeIn, dive.Duration,
+			site.Name, site.Region, site.Coordinates, trip.Label,
+			dive.DepthMax, dive.DepthMean, dive.TempWaterMin, dive.TempAir, dive.Salinity,
+			dive.Buddy, dive.OperatorDM, dive.Suit, dive.cylinder, dive.Gas,
+			dive.StartPressure, dive.EndPressure, dive.Weights, dive.WeightsType, dive.DCModel,
+			strconv.Itoa(dive.Rating5), strconv.Itoa(dive.Visibility5),
+			strings.Join(dive.Tags, ", "), dive.Award, dive.Notes,
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// Subset of UDDF 3.2 (Universal Dive Data Format), with the data available in the dive log.
+// Lengths are in meters, durations in seconds, and temperatures in Kelvin.
+
+const uddfVersion = "3.2.3"
+
+type uddfDocument struct {
+	XMLName     xml.Name       `xml:"uddf"`
+	Version     string         `xml:"version,attr"`
+	Generator   uddfGenerator  `xml:"generator"`
+	Diver       uddfDiver      `xml:"diver"`
+	DiveSites   []*uddfSite    `xml:"divesite>site"`
+	DiveTrips   []*uddfTrip    `xml:"divetrip>trip"`
+	Repetitions []*uddfRepeats `xml:"profiledata>repetitiongroup"`
+}
+
+type uddfGenerator struct {
+	Name     string `xml:"name"`
+	Type     string `xml:"type"`
+	DateTime string `xml:"datetime"`
+}
+
+type uddfDiver struct {
+	Owner   uddfPerson    `xml:"owner"`
+	Buddies []*uddfPerson `xml:"buddy"`
+}
+
+type uddfPerson struct {
+	ID        string `xml:"id,attr"`
+	FirstName string `xml:"personal>firstname,omitempty"`
+}
+
+type uddfSite struct {
+	ID        string     `xml:"id,attr"`
+	Name      string     `xml:"name"`
+	Location  string     `xml:"geography>location,omitempty"`
+	Latitude  string     `xml:"geography>latitude,omitempty"`
+	Longitude string     `xml:"geography>longitude,omitempty"`
+	Notes     *uddfNotes `xml:"notes,omitempty"`
+}
+
+type uddfTrip struct {
+	ID   string `xml:"id,attr"`
+	Name string `xml:"name"`
+}
+
+type uddfRepeats struct {
+	ID    string      `xml:"id,attr"`
+	Dives []*uddfDive `xml:"dive"`
+}
+
+type uddfDive struct {
+	ID     string         `xml:"id,attr"`
+	Before uddfBeforeDive `xml:"informationbeforedive"`
+	After  uddfAfterDive  `xml:"informationafterdive"`
+}
+
+type uddfBeforeDive struct {
+	Links          []uddfLink `xml:"link"`
+	DateTime       string     `xml:"datetime"`
+	DiveNumber     int        `xml:"divenumber,omitempty"`
+	AirTemperature string     `xml:"airtemperature,omitempty"`
+}
+
+type uddfAfterDive struct {
+	GreatestDepth     string     `xml:"greatestdepth,omitempty"`
+	AverageDepth      string     `xml:"averagedepth,omitempty"`
+	DiveDuration      int        `xml:"diveduration,omitempty"`
+	LowestTemperature string     `xml:"lowesttemperature,omitempty"`
+	Notes             *uddfNotes `xml:"notes,omitempty"`
+	Rating            int        `xml:"rating>ratingvalue,omitempty"`
+}
+
+type uddfLink struct {
+	Ref string `xml:"ref,attr"`
+}
+
+type uddfNotes struct {
+	Paragraphs []string `xml:"para"`
+}
+
+func exportUDDF(w io.Writer, divelog *DiveLog) error {
+	doc := &uddfDocument{
+		Version: uddfVersion,
+		Generator: uddfGenerator{
+			Name:     "bluefin",
+			Type:     "logbook",
+			DateTime: time.Now().UTC().Format("2006-01-02T15:04:05"),
+		},
+		Diver: uddfDiver{Owner: uddfPerson{ID: "owner"}},
+	}
+
+	for _, site := range divelog.DiveSites[1:] {
+		uddfSite := &uddfSite{
+			ID:       "site-" + site.StableID,
+			Name:     site.Name,
+			Location: site.Region,
+			Notes:    newUDDFNotes(site.Description),
+		}
+		if coords := strings.Fields(site.Coordinates); len(coords) == 2 {
+			uddfSite.Latitude, uddfSite.Longitude = coords[0], coords[1]
+		}
+		doc.DiveSites = append(doc.DiveSites, uddfSite)
+	}
+
+	for _, trip := range divelog.DiveTrips[1:] {
+		doc.DiveTrips = append(doc.DiveTrips, &uddfTrip{ID: "trip-" + trip.StableID, Name: trip.Label})
+	}
+
+	buddies := make(map[string]bool)
+	for _, dive := range divelog.Dives[1:] {
+		links := []uddfLink{
+			{Ref: "site-" + divelog.DiveSites[dive.DiveSiteID].StableID},
+			{Ref: "trip-" + divelog.DiveTrips[dive.DiveTripID].StableID},
+		}
+		for _, name := range dive.Buddies() {
+			id := "buddy-" + divelog.index.buddySlugs[name]
+			if !buddies[id] {
+				buddies[id] = true
+				doc.Diver.Buddies = append(doc.Diver.Buddies, &uddfPerson{ID: id, FirstName: name})
+			}
+			links = append(links, uddfLink{Ref: id})
+		}
+
+		// each dive is in its own repetition group, since surface intervals are not known
+		doc.Repetitions = append(doc.Repetitions, &uddfRepeats{
+			ID: "group-" + dive.StableID,
+			Dives: []*uddfDive{{
+				ID: "dive-" + dive.StableID,
+				Before: uddfBeforeDive{
+					Links:          links,
+					DateTime:       dive.datetime.Format("2006-01-02T15:04:05"),
+					DiveNumber:     dive.Number,
+					AirTemperature: uddfKelvin(dive.TempAir),
+				},
+				After: uddfAfterDive{
+					GreatestDepth:     uddfMeters(dive.DepthMax),
+					AverageDepth:      uddfMeters(dive.DepthMean),
+					DiveDuration:      int(dive.duration.Seconds()),
+					LowestTemperature: uddfKelvin(dive.TempWaterMin),
+					Notes:             newUDDFNotes(dive.Notes),
+					Rating:            dive.Rating5 * 2,
+				},
+			}},
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func newUDDFNotes(text string) *uddfNotes {
+	if text == "" {
+		return nil
+	}
+	return &uddfNotes{Paragraphs: strings.Split(text, "\n")}
+}
+
+// uddfMeters converts a Subsurface measurement in meters, e.g. "18.5 m".
+func uddfMeters(measurement string) string {
+	if measurement == "" {
+		return ""
+	}
+	return strconv.FormatFloat(utils.ParseMeasurement(measurement), 'f', -1, 64)
+}
+
+// uddfKelvin converts a Subsurface temperature in Celsius, e.g. "24.0 C".
+func uddfKelvin(measurement string) string {
+	if measurement == "" {
+		return ""
+	}
+	return fmt.Sprintf("%.2f", utils.ParseMeasurement(measurement)+273.15)
+}
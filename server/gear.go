@@ -0,0 +1,373 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"src.acicovic.me/divelog/server/utils"
+)
+
+// Equipment is not modeled in the source file, so the inventory is derived
+// from equipment names recorded with each dive.
+
+const (
+	GearSuit     = "suit"
+	GearCylinder = "cylinder"
+	GearWeights  = "weights"
+	GearComputer = "computer"
+)
+
+type gearKind struct {
+	kind   string
+	title  string
+	nameOf func(*Dive) string
+}
+
+var _gear_kinds = []gearKind{
+	{GearSuit, "Suits", func(d *Dive) string { return d.Suit }},
+	{GearCylinder, "Cylinders", func(d *Dive) string { return d.cylinder }},
+	{GearWeights, "Weight systems", func(d *Dive) string { return d.WeightsType }},
+	{GearComputer, "Dive computers", func(d *Dive) string { return d.DCModel }},
+}
+
+type GearItem struct {
+	Kind string `json:"kind"`
+	Slug string `json:"slug"`
+	Name string `json:"name"`
+	*Usage
+}
+
+type GearItemFull struct {
+	*GearItem
+	LinkedDives []*DiveHead `json:"linked_dives"`
+}
+
+type GearGroup struct {
+	Title string
+	Items []*GearItem
+}
+
+// CollectGear returns all equipment used in the dive log,
+// sorted by kind (in the order of _gear_kinds) and name.
+func CollectGear(divelog *DiveLog) []*GearItemFull {
+	var items []*GearItemFull
+	for _, gk := range _gear_kinds {
+		nameOf := gk.nameOf
+		groups := groupDives(divelog, func(d *Dive) []string {
+			if name := nameOf(d); name != "" {
+				return []string{name}
+			}
+			return nil
+		})
+		for _, group := range groups {
+			items = append(items, &GearItemFull{
+				GearItem: &GearItem{
+					Kind:  gk.kind,
+					Slug:  group.slug,
+					Name:  group.name,
+					Usage: group.usage,
+				},
+				LinkedDives: group.dives,
+			})
+		}
+	}
+	return items
+}
+
+func FindGearItem(items []*GearItemFull, kind string, slug string) *GearItemFull {
+	for _, item := range items {
+		if item.Kind == kind && item.Slug == slug {
+			return item
+		}
+	}
+	return nil
+}
+
+func GroupGear(items []*GearItemFull) []*GearGroup {
+	groups := make([]*GearGroup, 0, len(_gear_kinds))
+	for _, gk := range _gear_kinds {
+		group := &GearGroup{Title: gk.title}
+		for _, item := range items {
+			if item.Kind == gk.kind {
+				group.Items = append(group.Items, item.GearItem)
+			}
+		}
+		if len(group.Items) > 0 {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+func gearKindTitle(kind string) string {
+	for _, gk := range _gear_kinds {
+		if gk.kind == kind {
+			return gk.title
+		}
+	}
+	return ""
+}
+
+const (
+	ServiceOK      = "ok"
+	ServiceDueSoon = "due soon"
+	ServiceOverdue = "overdue"
+
+	// fraction of any service interval after which the service is reported as due soon
+	serviceDueSoonThreshold = 0.9
+)
+
+// GearConfig is the list of serviceable items, loaded from a JSON file.
+// Dives are matched to items with special dive tags in the format _gear_{id}.
+type GearConfig struct {
+	Items []*ServiceableItem `json:"items"`
+}
+
+type ServiceableItem struct {
+	ID       string             `json:"id"`
+	Name     string             `json:"name"`
+	Kind     string             `json:"kind"`
+	Services []*ServiceInterval `json:"services"`
+}
+
+// ServiceInterval describes one kind of service of an item, e.g. a regulator
+// service or a cylinder hydrostatic test. The service is due when any of the
+// non-zero limits is reached, counting from the date of the last service.
+type ServiceInterval struct {
+	Name        string  `json:"name"`
+	Last        string  `json:"last"`
+	EveryDives  int     `json:"every_dives,omitempty"`
+	EveryHours  float64 `json:"every_hours,omitempty"`
+	EveryMonths int     `json:"every_months,omitempty"`
+
+	last time.Time
+}
+
+type GearServiceReport struct {
+	Configured bool
+	Items      []*ServiceItemStatus
+}
+
+type ServiceItemStatus struct {
+	ID        string           `json:"id"`
+	Name      string           `json:"name"`
+	Kind      string           `json:"kind"`
+	DiveCount int              `json:"dive_count"`
+	Status    string           `json:"status"`
+	Services  []*ServiceStatus `json:"services"`
+}
+
+type ServiceStatus struct {
+	Name        string `json:"name"`
+	LastService string `json:"last_service"`
+	DivesSince  int    `json:"dives_since"`
+	EveryDives  int    `json:"every_dives,omitempty"`
+	HoursSince  string `json:"hours_since"`
+	EveryHours  string `json:"every_hours,omitempty"`
+	DueDate     string `json:"due_date,omitempty"`
+	Status      string `json:"status"`
+}
+
+// Only builder() accesses these, see _divelog.
+var (
+	_gear_config         *GearConfig
+	_gear_config_modTime time.Time
+	_gear_config_checked bool
+)
+
+// LoadGearConfig reads and validates the serviceable items configuration.
+// All problems found in the file are reported together.
+func LoadGearConfig(path string) (*GearConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &GearConfig{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse gear config %s: %v", path, err)
+	}
+
+	var (
+		problems []error
+		ids      = make(map[string]bool)
+	)
+	for i, item := range config.Items {
+		if item.ID == "" || utils.Slug(item.ID) != item.ID {
+			problems = append(problems, fmt.Errorf("item %d: id %q must be non-empty, lowercase and contain only letters, digits and hyphens", i+1, item.ID))
+		} else if ids[item.ID] {
+			problems = append(problems, fmt.Errorf("item %d: duplicate id %q", i+1, item.ID))
+		}
+		ids[item.ID] = true
+
+		if item.Name == "" {
+			item.Name = item.ID
+		}
+		if len(item.Services) == 0 {
+			problems = append(problems, fmt.Errorf("item %q: no services defined", item.ID))
+		}
+		for _, service := range item.Services {
+			if service.last, err = time.Parse(time.DateOnly, service.Last); err != nil {
+				problems = append(problems, fmt.Errorf("item %q: service %q: invalid date %q, expected yyyy-mm-dd", item.ID, service.Name, service.Last))
+			}
+			if service.EveryDives < 0 || service.EveryHours < 0 || service.EveryMonths < 0 {
+				problems = append(problems, fmt.Errorf("item %q: service %q: negative interval", item.ID, service.Name))
+			}
+			if service.EveryDives == 0 && service.EveryHours == 0 && service.EveryMonths == 0 {
+				problems = append(problems, fmt.Errorf("item %q: service %q: no interval defined", item.ID, service.Name))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid gear config %s: %w", path, errors.Join(problems...))
+	}
+	return config, nil
+}
+
+// reloadGearConfig checks the gear configuration file, and reloads it if it changed
+// since the last check. An invalid or missing file disables service tracking.
+// Returns true if the configuration in use changed.
+func reloadGearConfig() bool {
+	path := _control_block.gearConfigPath
+	if path == "" {
+		return false
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		// report a missing file only once
+		if _gear_config_checked && _gear_config_modTime.IsZero() {
+			return false
+		}
+		_gear_config_checked = true
+		trace(_error, "gear service tracking disabled: %v", err)
+		changed := _gear_config != nil
+		_gear_config, _gear_config_modTime = nil, time.Time{}
+		return changed
+	}
+	if _gear_config_checked && info.ModTime().Equal(_gear_config_modTime) {
+		return false
+	}
+	_gear_config_checked = true
+
+	// remember the modification time of invalid files too, to report them only once
+	_gear_config_modTime = info.ModTime()
+	gearConfig, err := LoadGearConfig(path)
+	if err != nil {
+		trace(_error, "gear service tracking disabled: %v", err)
+		changed := _gear_config != nil
+		_gear_config = nil
+		return changed
+	}
+
+	_gear_config = gearConfig
+	trace(_build, "loaded %d serviceable gear items from %s", len(gearConfig.Items), path)
+	return true
+}
+
+// isGearConfigFile reports whether the named file in the watch directory is the gear configuration file.
+func isGearConfigFile(name string) bool {
+	path := _control_block.gearConfigPath
+	return path != "" && filepath.Clean(path) == filepath.Join(_control_block.watchDirectoryPath, name)
+}
+
+// CheckServiceIntervals evaluates every service interval of every configured item
+// against the dives tagged with the item, as of the UTC date of now, so that the
+// report only changes once a day, like the key of cached responses.
+// Overdue items are listed first.
+func CheckServiceIntervals(divelog *DiveLog, now time.Time) []*ServiceItemStatus {
+	if divelog.gearConfig == nil {
+		return []*ServiceItemStatus{}
+	}
+	now = now.UTC().Truncate(24 * time.Hour)
+
+	statuses := make([]*ServiceItemStatus, 0, len(divelog.gearConfig.Items))
+	for _, item := range divelog.gearConfig.Items {
+		itemStatus := &ServiceItemStatus{
+			ID:     item.ID,
+			Name:   item.Name,
+			Kind:   item.Kind,
+			Status: ServiceOK,
+		}
+
+		var dives []*Dive
+		for _, dive := range divelog.Dives[1:] {
+			if dive.UsedGear(item.ID) {
+				dives = append(dives, dive)
+			}
+		}
+		itemStatus.DiveCount = len(dives)
+
+		for _, service := range item.Services {
+			status := checkServiceInterval(service, dives, now)
+			itemStatus.Services = append(itemStatus.Services, status)
+			itemStatus.Status = worseServiceStatus(itemStatus.Status, status.Status)
+		}
+		statuses = append(statuses, itemStatus)
+	}
+
+	rank := map[string]int{ServiceOverdue: 0, ServiceDueSoon: 1, ServiceOK: 2}
+	sort.SliceStable(statuses, func(i, j int) bool {
+		return rank[statuses[i].Status] < rank[statuses[j].Status]
+	})
+	return statuses
+}
+
+func checkServiceInterval(service *ServiceInterval, dives []*Dive, now time.Time) *ServiceStatus {
+	var (
+		divesSince int
+		timeSince  time.Duration
+		usage      float64
+	)
+	for _, dive := range dives {
+		if !dive.datetime.Before(service.last) {
+			divesSince++
+			timeSince += dive.duration
+		}
+	}
+
+	status := &ServiceStatus{
+		Name:        service.Name,
+		LastService: service.Last,
+		DivesSince:  divesSince,
+		EveryDives:  service.EveryDives,
+		HoursSince:  utils.FormatHoursMinutes(timeSince),
+	}
+	if service.EveryDives > 0 {
+		usage = max(usage, float64(divesSince)/float64(service.EveryDives))
+	}
+	if service.EveryHours > 0 {
+		status.EveryHours = utils.FormatHoursMinutes(time.Duration(service.EveryHours * float64(time.Hour)))
+		usage = max(usage, timeSince.Hours()/service.EveryHours)
+	}
+	if service.EveryMonths > 0 {
+		due := service.last.AddDate(0, service.EveryMonths, 0)
+		status.DueDate = due.Format(time.DateOnly)
+		usage = max(usage, float64(now.Sub(service.last))/float64(due.Sub(service.last)))
+	}
+
+	switch {
+	case usage >= 1:
+		status.Status = ServiceOverdue
+	case usage >= serviceDueSoonThreshold:
+		status.Status = ServiceDueSoon
+	default:
+		status.Status = ServiceOK
+	}
+	return status
+}
+
+func worseServiceStatus(a string, b string) string {
+	if a == ServiceOverdue || b == ServiceOverdue {
+		return ServiceOverdue
+	}
+	if a == ServiceDueSoon || b == ServiceDueSoon {
+		return ServiceDueSoon
+	}
+	return ServiceOK
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckServiceIntervals(t *testing.T) {
+	last := time.Date(2023, time.January, 10, 0, 0, 0, 0, time.UTC)
+	// three 50-minute dives with the regulator, one before its last service
+	divelog := &DiveLog{Dives: []*Dive{nil,
+		{ID: 1, datetime: last.AddDate(0, 0, -1), duration: 50 * time.Minute, gear: []string{"reg"}},
+		{ID: 2, datetime: last.AddDate(0, 0, 1), duration: 50 * time.Minute, gear: []string{"reg"}},
+		{ID: 3, datetime: last.AddDate(0, 0, 2), duration: 50 * time.Minute, gear: []string{"reg", "bcd"}},
+	}}
+	now := time.Date(2023, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		service ServiceInterval
+		now     time.Time
+		want    string
+	}{
+		{"dives ok", ServiceInterval{EveryDives: 10}, now, ServiceOK},
+		{"dives overdue", ServiceInterval{EveryDives: 2}, now, ServiceOverdue},
+		{"hours ok", ServiceInterval{EveryHours: 10}, now, ServiceOK},
+		{"hours due soon", ServiceInterval{EveryHours: 1.8}, now, ServiceDueSoon},
+		{"hours overdue", ServiceInterval{EveryHours: 1.5}, now, ServiceOverdue},
+		{"months ok", ServiceInterval{EveryMonths: 12}, now, ServiceOK},
+		{"months due soon", ServiceInterval{EveryMonths: 12}, last.AddDate(0, 11, 15), ServiceDueSoon},
+		{"months overdue on the due date", ServiceInterval{EveryMonths: 12}, last.AddDate(1, 0, 0), ServiceOverdue},
+		{"months due soon until the due date", ServiceInterval{EveryMonths: 12}, last.AddDate(1, 0, 0).Add(-time.Minute), ServiceDueSoon},
+		{"first limit reached wins", ServiceInterval{EveryDives: 100, EveryMonths: 1}, now, ServiceOverdue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := tt.service
+			service.Name, service.Last, service.last = "service", last.Format(time.DateOnly), last
+			divelog.gearConfig = &GearConfig{Items: []*ServiceableItem{
+				{ID: "reg", Name: "Regulator", Services: []*ServiceInterval{&service}},
+			}}
+			statuses := CheckServiceIntervals(divelog, tt.now)
+			if len(statuses) != 1 {
+				t.Fatalf("%d statuses, want 1", len(statuses))
+			}
+			if got := statuses[0].Status; got != tt.want {
+				t.Errorf("status = %q, want %q", got, tt.want)
+			}
+			if got := statuses[0].Services[0].DivesSince; got != 2 {
+				t.Errorf("dives since the last service = %d, want 2", got)
+			}
+		})
+	}
+}
+
+func TestCheckServiceIntervalsOrder(t *testing.T) {
+	last := time.Date(2023, time.January, 10, 0, 0, 0, 0, time.UTC)
+	divelog := &DiveLog{
+		Dives: []*Dive{nil},
+		gearConfig: &GearConfig{Items: []*ServiceableItem{
+			{ID: "ok", Services: []*ServiceInterval{{EveryMonths: 24, last: last}}},
+			{ID: "soon", Services: []*ServiceInterval{{EveryMonths: 24, last: last}, {EveryMonths: 12, last: last.AddDate(0, -1, 0)}}},
+			{ID: "overdue", Services: []*ServiceInterval{{EveryMonths: 6, last: last}}},
+		}},
+	}
+	statuses := CheckServiceIntervals(divelog, last.AddDate(0, 10, 15))
+	var got []string
+	for _, status := range statuses {
+		got = append(got, status.ID+" "+status.Status)
+	}
+	want := []string{"overdue " + ServiceOverdue, "soon " + ServiceDueSoon, "ok " + ServiceOK}
+	if len(got) != len(want) {
+		t.Fatalf("statuses = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("statuses = %q, want %q", got, want)
+			break
+		}
+	}
+
+	if got := CheckServiceIntervals(&DiveLog{}, last); got == nil || len(got) != 0 {
+		t.Errorf("without a configuration, statuses = %v, want none", got)
+	}
+}
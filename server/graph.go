@@ -0,0 +1,198 @@
+package server
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"sort"
+	"strings"
+)
+
+const (
+	graphWidth      = 900.0
+	graphHeight     = 600.0
+	graphMargin     = 60.0
+	graphIterations = 300
+)
+
+// BuddyGraph is a weighted, undirected co-diving graph. Node weight is the number
+// of dives with a buddy, edge weight is the number of dives two buddies shared.
+type BuddyGraph struct {
+	Nodes []*GraphNode `json:"nodes"`
+	Edges []*GraphEdge `json:"edges"`
+}
+
+type GraphNode struct {
+	*PersonHead
+	Weight int `json:"weight"`
+
+	x, y float64
+}
+
+type GraphEdge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Weight int    `json:"weight"`
+
+	source, target *GraphNode
+}
+
+// NewBuddyGraph builds the co-diving graph from buddy lists of all dives.
+// Nodes and edges are sorted, so that the graph and its layout are deterministic.
+func NewBuddyGraph(divelog *DiveLog) *BuddyGraph {
+	var (
+		g     = &BuddyGraph{Nodes: []*GraphNode{}, Edges: []*GraphEdge{}}
+		nodes = make(map[string]*GraphNode)
+		edges = make(map[[2]string]*GraphEdge)
+	)
+	for _, dive := range divelog.Dives[1:] {
+		buddies := NewPersonHeads(dive.Buddies(), divelog.index.buddySlugs)
+		for i, buddy := range buddies {
+			node, ok := nodes[buddy.Slug]
+			if !ok {
+				node = &GraphNode{PersonHead: buddy}
+				nodes[buddy.Slug] = node
+				g.Nodes = append(g.Nodes, node)
+			}
+			node.Weight++
+
+			for _, other := range buddies[:i] {
+				key := [2]string{min(buddy.Slug, other.Slug), max(buddy.Slug, other.Slug)}
+				edge, ok := edges[key]
+				if !ok {
+					edge = &GraphEdge{Source: key[0], Target: key[1]}
+					edges[key] = edge
+					g.Edges = append(g.Edges, edge)
+				}
+				edge.Weight++
+			}
+		}
+	}
+
+	sort.Slice(g.Nodes, func(i, j int) bool {
+		return g.Nodes[i].Slug < g.Nodes[j].Slug
+	})
+	sort.Slice(g.Edges, func(i, j int) bool {
+		if g.Edges[i].Source != g.Edges[j].Source {
+			return g.Edges[i].Source < g.Edges[j].Source
+		}
+		return g.Edges[i].Target < g.Edges[j].Target
+	})
+	for _, edge := range g.Edges {
+		edge.source, edge.target = nodes[edge.Source], nodes[edge.Target]
+	}
+
+	return g
+}
+
+// layout positions the nodes with the Fruchterman-Reingold algorithm, extended with
+// weak gravity toward the center so that disconnected nodes stay on the canvas.
+// Nodes start on a circle in sorted order, and no randomness is involved.
+func (g *BuddyGraph) layout() {
+	n := len(g.Nodes)
+	if n == 0 {
+		return
+	}
+
+	const (
+		cx, cy  = graphWidth / 2, graphHeight / 2
+		gravity = 0.05
+	)
+	var (
+		area = (graphWidth - 2*graphMargin) * (graphHeight - 2*graphMargin)
+		k    = math.Sqrt(area / float64(n))
+		temp = graphWidth / 10
+		dx   = make([]float64, n)
+		dy   = make([]float64, n)
+		idx  = make(map[*GraphNode]int, n)
+	)
+	for i, node := range g.Nodes {
+		angle := 2 * math.Pi * float64(i) / float64(n)
+		node.x = cx + (graphWidth/2-graphMargin)*math.Cos(angle)
+		node.y = cy + (graphHeight/2-graphMargin)*math.Sin(angle)
+		idx[node] = i
+	}
+
+	for iter := 0; iter < graphIterations; iter++ {
+		for i := range dx {
+			dx[i], dy[i] = 0, 0
+		}
+
+		for i := 0; i < n; i++ {
+			for j := i + 1; j < n; j++ {
+				ddx, ddy, dist := distance(g.Nodes[i], g.Nodes[j])
+				force := k * k / dist
+				dx[i] += ddx / dist * force
+				dy[i] += ddy / dist * force
+				dx[j] -= ddx / dist * force
+				dy[j] -= ddy / dist * force
+			}
+		}
+
+		for _, edge := range g.Edges {
+			i, j := idx[edge.source], idx[edge.target]
+			ddx, ddy, dist := distance(edge.source, edge.target)
+			force := dist * dist / k * math.Log1p(float64(edge.Weight))
+			dx[i] -= ddx / dist * force
+			dy[i] -= ddy / dist * force
+			dx[j] += ddx / dist * force
+			dy[j] += ddy / dist * force
+		}
+
+		for i, node := range g.Nodes {
+			dx[i] += (cx - node.x) * gravity * k / 10
+			dy[i] += (cy - node.y) * gravity * k / 10
+			if length := math.Hypot(dx[i], dy[i]); length > 0 {
+				step := math.Min(length, temp)
+				node.x += dx[i] / length * step
+				node.y += dy[i] / length * step
+			}
+			node.x = math.Max(graphMargin, math.Min(graphWidth-graphMargin, node.x))
+			node.y = math.Max(graphMargin, math.Min(graphHeight-graphMargin, node.y))
+		}
+
+		temp *= 1 - 1/float64(graphIterations)
+	}
+}
+
+func distance(a *GraphNode, b *GraphNode) (dx float64, dy float64, dist float64) {
+	dx, dy = a.x-b.x, a.y-b.y
+	dist = math.Hypot(dx, dy)
+	if dist < 0.01 {
+		// nodes on the same spot are pushed apart in a fixed direction
+		dx, dy, dist = 0.01, 0, 0.01
+	}
+	return
+}
+
+// SVG lays out the graph and renders it as an inline SVG image.
+func (g *BuddyGraph) SVG() template.HTML {
+	g.layout()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg class="graph" viewBox="0 0 %.0f %.0f" xmlns="http://www.w3.org/2000/svg">`, graphWidth, graphHeight)
+	for _, edge := range g.Edges {
+		fmt.Fprintf(
+			&b,
+			`<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#B3D9FF" stroke-width="%.1f"><title>%s, %s: %d</title></line>`,
+			edge.source.x, edge.source.y, edge.target.x, edge.target.y,
+			1+math.Log2(float64(edge.Weight)),
+			template.HTMLEscapeString(edge.source.Name), template.HTMLEscapeString(edge.target.Name), edge.Weight,
+		)
+	}
+	for _, node := range g.Nodes {
+		fmt.Fprintf(
+			&b,
+			`<a href="/hms/buddies/%s"><circle cx="%.1f" cy="%.1f" r="%.1f" fill="#4A90E2"><title>%s: %d</title></circle>`+
+				`<text x="%.1f" y="%.1f" text-anchor="middle" font-size="14" fill="#003D7A">%s</text></a>`,
+			template.URLQueryEscaper(node.Slug),
+			node.x, node.y, 4+3*math.Sqrt(float64(node.Weight)),
+			template.HTMLEscapeString(node.Name), node.Weight,
+			node.x, node.y-8-3*math.Sqrt(float64(node.Weight)),
+			template.HTMLEscapeString(node.Name),
+		)
+	}
+	b.WriteString(`</svg>`)
+
+	return template.HTML(b.String())
+}
@@ -6,8 +6,9 @@ import (
 	"html/template"
 	"net/http"
 	"os"
-	"slices"
 	"sort"
+	"strings"
+	"time"
 
 	"src.acicovic.me/divelog/server/utils"
 )
@@ -21,9 +22,14 @@ const (
 	FileStyle        = "data" + PathStyle
 	ContentTypeWoff2 = "font/woff2"
 	ContentTypeCSS   = "text/css"
+	ContentTypeHTML  = "text/html; charset=utf-8"
 )
 
-var _page_template = template.Must(template.ParseFiles("data/pagetemplate.html"))
+const FilePageTemplate = "data/pagetemplate.html"
+
+// Parsed when the server starts, so that commands which do not serve pages
+// can run without the data directory.
+var _page_template *template.Template
 
 func defaultHandler(w http.ResponseWriter, r *http.Request) {
 	var filePath, contentType string
@@ -56,6 +62,7 @@ func defaultHandler(w http.ResponseWriter, r *http.Request) {
 	if contentType != "" {
 		w.Header().Set("Content-Type", contentType)
 	}
+	w.Header().Set("Cache-Control", CacheControlStatic)
 
 	http.ServeContent(w, r, r.URL.Path[1:], fi.ModTime(), file)
 }
@@ -68,26 +75,24 @@ func fetchSites(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
 
 	if r.URL.Query().Get("headonly") == "true" {
 		heads := make([]*SiteHead, 0, len(divelog.DiveSites))
-		for _, site := range divelog.DiveSites[1:] {
+		for _, site := range divelog.index.sitesByName {
 			heads = append(heads, &SiteHead{
-				ID:   site.ID,
-				Name: site.Name,
+				ID:       site.ID,
+				StableID: site.StableID,
+				Name:     site.Name,
 			})
 		}
-		sort.Slice(heads, func(i, j int) bool {
-			return heads[i].Name < heads[j].Name
-		})
 		resp, err = json.Marshal(heads)
 	} else {
 		sites := []*SiteFull{}
 		for _, site := range divelog.DiveSites[1:] {
-			sites = append(sites, NewSiteFull(site, divelog.Dives[1:]))
+			sites = append(sites, NewSiteFull(site, divelog))
 		}
 		resp, err = json.Marshal(sites)
 	}
 
 	if err != nil {
-		trace(_error, "http: failed to marshal dive site data: %v", err)
+		traceContext(r.Context(), _error, "http: failed to marshal dive site data: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -96,16 +101,19 @@ func fetchSites(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
 }
 
 func fetchSite(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
-	siteID := utils.ConvertAndCheckID(r.PathValue("id"), divelog.LargestSiteID())
+	siteID, redirected := resolveSiteID(w, r, divelog)
+	if redirected {
+		return
+	}
 	if siteID == 0 {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 	site := divelog.DiveSites[siteID]
 
-	resp, err := json.Marshal(NewSiteFull(site, divelog.Dives[1:]))
+	resp, err := json.Marshal(NewSiteFull(site, divelog))
 	if err != nil {
-		trace(_error, "http: failed to marshal single dive site data: %v", err)
+		traceContext(r.Context(), _error, "http: failed to marshal single dive site data: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -119,33 +127,48 @@ func fetchTrips(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
 	if reverse {
 		for _, trip := range divelog.DiveTrips[1:] {
 			trips = append(trips, &Trip{
-				ID:    trip.ID,
-				Label: trip.Label,
+				ID:       trip.ID,
+				StableID: trip.StableID,
+				Label:    trip.Label,
 			})
 		}
 	} else {
 		for i := len(divelog.DiveTrips) - 1; i > 0; i-- {
 			trips = append(trips, &Trip{
-				ID:    divelog.DiveTrips[i].ID,
-				Label: divelog.DiveTrips[i].Label,
+				ID:       divelog.DiveTrips[i].ID,
+				StableID: divelog.DiveTrips[i].StableID,
+				Label:    divelog.DiveTrips[i].Label,
 			})
 		}
 	}
 
 	for _, trip := range trips {
-		for _, dive := range divelog.Dives[1:] {
-			if dive.DiveTripID == trip.ID {
-				trip.LinkedDives = append(trip.LinkedDives, NewDiveHead(dive, divelog.DiveSites[dive.DiveSiteID]))
-			}
-		}
-		if !reverse {
-			slices.Reverse(trip.LinkedDives)
-		}
+		trip.LinkedDives = divelog.index.diveHeads(divelog.index.byTrip[trip.ID], !reverse)
 	}
 
 	resp, err := json.Marshal(trips)
 	if err != nil {
-		trace(_error, "http: failed to marshal dive trip data: %v", err)
+		traceContext(r.Context(), _error, "http: failed to marshal dive trip data: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	send(w, resp)
+}
+
+func fetchTrip(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
+	tripID, redirected := resolveTripID(w, r, divelog)
+	if redirected {
+		return
+	}
+	if tripID == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	resp, err := json.Marshal(NewTripFull(divelog.DiveTrips[tripID], divelog))
+	if err != nil {
+		traceContext(r.Context(), _error, "http: failed to marshal single dive trip data: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -162,22 +185,22 @@ func fetchDives(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
 
 	if r.URL.Query().Get("headonly") == "true" {
 		heads := make([]*DiveHead, 0, len(divelog.Dives))
-		for _, dive := range divelog.Dives[1:] {
-			heads = append(heads, NewDiveHead(dive, divelog.DiveSites[dive.DiveSiteID]))
-		}
+		heads = append(heads, divelog.index.heads[1:]...)
 		resp, err = json.Marshal(heads)
 	} else {
-		dives := []*DiveFull{}
-		for _, dive := range divelog.Dives[1:] {
-			if dive.IsTaggedWith(tag) {
-				dives = append(dives, NewDiveFull(dive, divelog.DiveSites[dive.DiveSiteID]))
-			}
+		tagged := divelog.Dives[1:]
+		if tag != "" {
+			tagged = divelog.index.byTag[tag]
+		}
+		dives := make([]*DiveFull, 0, len(tagged))
+		for _, dive := range tagged {
+			dives = append(dives, NewDiveFull(dive, divelog))
 		}
 		resp, err = json.Marshal(dives)
 	}
 
 	if err != nil {
-		trace(_error, "http: failed to marshal dive data: %v", err)
+		traceContext(r.Context(), _error, "http: failed to marshal dive data: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -186,16 +209,19 @@ func fetchDives(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
 }
 
 func fetchDive(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
-	diveID := utils.ConvertAndCheckID(r.PathValue("id"), divelog.LargestDiveID())
+	diveID, redirected := resolveDiveID(w, r, divelog)
+	if redirected {
+		return
+	}
 	if diveID == 0 {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 	dive := divelog.Dives[diveID]
 
-	resp, err := json.Marshal(NewDiveFull(dive, divelog.DiveSites[dive.DiveSiteID]))
+	resp, err := json.Marshal(NewDiveFull(dive, divelog))
 	if err != nil {
-		trace(_error, "http: failed to marshal single dive data: %v", err)
+		traceContext(r.Context(), _error, "http: failed to marshal single dive data: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -204,16 +230,147 @@ func fetchDive(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
 }
 
 func fetchTags(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
-	tags := make(map[string]int)
-	for _, dive := range divelog.Dives[1:] {
-		for _, tag := range dive.Tags {
-			tags[tag]++
-		}
+	resp, err := json.Marshal(divelog.index.tagCounts)
+	if err != nil {
+		traceContext(r.Context(), _error, "http: failed to marshal tags data: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	send(w, resp)
+}
+
+func fetchBuddies(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
+	fetchPeople(w, r, divelog.index.buddies)
+}
+
+func fetchBuddy(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
+	fetchPerson(w, r, divelog.index.buddies)
+}
+
+func fetchOperators(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
+	fetchPeople(w, r, divelog.index.operators)
+}
+
+func fetchOperator(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
+	fetchPerson(w, r, divelog.index.operators)
+}
+
+func fetchPeople(w http.ResponseWriter, r *http.Request, people []*PersonFull) {
+	heads := make([]*Person, 0, len(people))
+	for _, person := range people {
+		heads = append(heads, person.Person)
+	}
+
+	resp, err := json.Marshal(heads)
+	if err != nil {
+		traceContext(r.Context(), _error, "http: failed to marshal people data: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	send(w, resp)
+}
+
+func fetchPerson(w http.ResponseWriter, r *http.Request, people []*PersonFull) {
+	person := findPerson(r, people)
+	if person == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	resp, err := json.Marshal(person)
+	if err != nil {
+		traceContext(r.Context(), _error, "http: failed to marshal single person data: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	send(w, resp)
+}
+
+func fetchBuddyGraph(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
+	resp, err := json.Marshal(NewBuddyGraph(divelog))
+	if err != nil {
+		traceContext(r.Context(), _error, "http: failed to marshal buddy graph data: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	send(w, resp)
+}
+
+func fetchGear(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
+	items := divelog.index.gear
+	heads := make([]*GearItem, 0, len(items))
+	for _, item := range items {
+		heads = append(heads, item.GearItem)
 	}
 
-	resp, err := json.Marshal(tags)
+	resp, err := json.Marshal(heads)
 	if err != nil {
-		trace(_error, "http: failed to marshal tags data: %v", err)
+		traceContext(r.Context(), _error, "http: failed to marshal gear data: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	send(w, resp)
+}
+
+func fetchGearItem(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
+	item := FindGearItem(divelog.index.gear, r.PathValue("kind"), utils.Slug(r.PathValue("name")))
+	if item == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	resp, err := json.Marshal(item)
+	if err != nil {
+		traceContext(r.Context(), _error, "http: failed to marshal single gear item data: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	send(w, resp)
+}
+
+func fetchGearService(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
+	resp, err := json.Marshal(CheckServiceIntervals(divelog, time.Now().UTC()))
+	if err != nil {
+		traceContext(r.Context(), _error, "http: failed to marshal gear service data: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	send(w, resp)
+}
+
+func fetchTraining(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
+	resp, err := json.Marshal(NewTrainingRecord(divelog))
+	if err != nil {
+		traceContext(r.Context(), _error, "http: failed to marshal training data: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	send(w, resp)
+}
+
+func fetchChanges(w http.ResponseWriter, r *http.Request) {
+	resp, err := json.Marshal(changeSets())
+	if err != nil {
+		traceContext(r.Context(), _error, "http: failed to marshal changes: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	send(w, resp)
+}
+
+func fetchValidation(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
+	resp, err := json.Marshal(NewValidationReport(divelog))
+	if err != nil {
+		traceContext(r.Context(), _error, "http: failed to marshal validation report: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -221,24 +378,15 @@ func fetchTags(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
 	send(w, resp)
 }
 
-// TODO: This function can be refactored to be similar to renderSites.
 func renderDives(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
 	trips := make([]*Trip, 0, len(divelog.DiveTrips))
 	for i := len(divelog.DiveTrips) - 1; i > 0; i-- {
-		trip := &Trip{
-			ID:    i,
-			Label: divelog.DiveTrips[i].Label,
-		}
-		for i := len(divelog.Dives) - 1; i > 0; i-- {
-			dive := divelog.Dives[i]
-			if dive.DiveTripID == trip.ID {
-				trip.LinkedDives = append(
-					trip.LinkedDives,
-					NewDiveHead(dive, divelog.DiveSites[dive.DiveSiteID]),
-				)
-			}
-		}
-		trips = append(trips, trip)
+		trips = append(trips, &Trip{
+			ID:          i,
+			StableID:    divelog.DiveTrips[i].StableID,
+			Label:       divelog.DiveTrips[i].Label,
+			LinkedDives: divelog.index.diveHeads(divelog.index.byTrip[i], true),
+		})
 	}
 
 	renderTemplate(w, Page{
@@ -250,18 +398,16 @@ func renderDives(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
 
 func renderSites(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
 	regionMap := make(map[string][]*SiteHead)
-	for _, site := range divelog.DiveSites[1:] {
+	for _, site := range divelog.index.sitesByName {
 		regionMap[site.Region] = append(regionMap[site.Region], &SiteHead{
-			ID:   site.ID,
-			Name: site.Name,
+			ID:       site.ID,
+			StableID: site.StableID,
+			Name:     site.Name,
 		})
 	}
 
 	siteHeads := make([]*GroupedSites, 0, len(regionMap))
 	for region, sites := range regionMap {
-		sort.Slice(sites, func(i, j int) bool {
-			return sites[i].Name < sites[j].Name
-		})
 		siteHeads = append(siteHeads, &GroupedSites{
 			Region:      region,
 			LinkedSites: sites,
@@ -279,8 +425,42 @@ func renderSites(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
 	})
 }
 
+func renderTrips(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
+	trips := make([]*TripFull, 0, len(divelog.DiveTrips))
+	for i := len(divelog.DiveTrips) - 1; i > 0; i-- {
+		trips = append(trips, NewTripFull(divelog.DiveTrips[i], divelog))
+	}
+
+	renderTemplate(w, Page{
+		Title:      "Trips",
+		Supertitle: "All",
+		AllTrips:   trips,
+	})
+}
+
+func renderTrip(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
+	tripID, redirected := resolveTripID(w, r, divelog)
+	if redirected {
+		return
+	}
+	if tripID == 0 {
+		renderNotFound(w, "trip not found")
+		return
+	}
+	trip := NewTripFull(divelog.DiveTrips[tripID], divelog)
+
+	renderTemplate(w, Page{
+		Title:      trip.Label,
+		Supertitle: trip.DateRangePretty,
+		Trip:       trip,
+	})
+}
+
 func renderDive(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
-	diveID := utils.ConvertAndCheckID(r.PathValue("id"), divelog.LargestDiveID())
+	diveID, redirected := resolveDiveID(w, r, divelog)
+	if redirected {
+		return
+	}
 	if diveID == 0 {
 		renderNotFound(w, "dive not found")
 		return
@@ -291,19 +471,23 @@ func renderDive(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
 	page := Page{
 		Title:      site.Name,
 		Supertitle: fmt.Sprintf("Dive %d", dive.Number),
-		Dive:       NewDiveFull(dive, site),
+		Dive:       NewDiveFull(dive, divelog),
+	}
+	if diveID > 1 {
+		page.Dive.PrevStableID = divelog.Dives[diveID-1].StableID
 	}
-	// fix it here because this is the only scenario where it's needed
-	// (although it's not a good design)
-	if page.Dive.NextID == len(divelog.Dives) {
-		page.Dive.NextID = 0
+	if diveID < divelog.LargestDiveID() {
+		page.Dive.NextStableID = divelog.Dives[diveID+1].StableID
 	}
 
 	renderTemplate(w, page)
 }
 
 func renderSite(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
-	siteID := utils.ConvertAndCheckID(r.PathValue("id"), divelog.LargestSiteID())
+	siteID, redirected := resolveSiteID(w, r, divelog)
+	if redirected {
+		return
+	}
 	if siteID == 0 {
 		renderNotFound(w, "site not found")
 		return
@@ -313,39 +497,21 @@ func renderSite(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
 	renderTemplate(w, Page{
 		Title:      site.Name,
 		Supertitle: site.Region,
-		Site:       NewSiteFull(site, divelog.Dives[1:]),
+		Site:       NewSiteFull(site, divelog),
 	})
 }
 
 func renderTags(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
-	tags := make(map[string]int)
-	for _, dive := range divelog.Dives[1:] {
-		for _, tag := range dive.Tags {
-			tags[tag]++
-		}
-	}
-
 	renderTemplate(w, Page{
 		Title:      "Tags",
 		Supertitle: "All",
-		Tags:       tags,
+		Tags:       divelog.index.tagCounts,
 	})
 }
 
 func renderTaggedDives(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
 	tag := r.PathValue("tag")
-	dives := []*DiveHead{}
-	for i := len(divelog.Dives) - 1; i > 0; i-- {
-		dive := divelog.Dives[i]
-		for _, t := range dive.Tags {
-			if t == tag {
-				dives = append(
-					dives,
-					NewDiveHead(dive, divelog.DiveSites[dive.DiveSiteID]),
-				)
-			}
-		}
-	}
+	dives := divelog.index.diveHeads(divelog.index.byTag[tag], true)
 
 	if len(dives) == 0 {
 		renderNotFound(w, "")
@@ -359,6 +525,160 @@ func renderTaggedDives(w http.ResponseWriter, r *http.Request, divelog *DiveLog)
 	})
 }
 
+func resolveDiveID(w http.ResponseWriter, r *http.Request, divelog *DiveLog) (int, bool) {
+	return resolveStableID(w, r, divelog.stableDiveIDs, divelog.LargestDiveID(), func(id int) string {
+		return divelog.Dives[id].StableID
+	})
+}
+
+func resolveSiteID(w http.ResponseWriter, r *http.Request, divelog *DiveLog) (int, bool) {
+	return resolveStableID(w, r, divelog.stableSiteIDs, divelog.LargestSiteID(), func(id int) string {
+		return divelog.DiveSites[id].StableID
+	})
+}
+
+func resolveTripID(w http.ResponseWriter, r *http.Request, divelog *DiveLog) (int, bool) {
+	return resolveStableID(w, r, divelog.stableTripIDs, divelog.LargestTripID(), func(id int) string {
+		return divelog.DiveTrips[id].StableID
+	})
+}
+
+// resolveStableID maps the {id} path value to a system ID. Legacy links which
+// use system IDs are redirected to the permalink built from the stable ID.
+// System IDs are not stable across rebuilds, so the redirect is not permanent.
+// Returns 0 if the object was not found, and true if the request was redirected.
+func resolveStableID(w http.ResponseWriter, r *http.Request, stableIDs map[string]int, max int, stableIDOf func(int) string) (int, bool) {
+	strid := r.PathValue("id")
+	if id, ok := stableIDs[strid]; ok {
+		return id, false
+	}
+
+	id := utils.ConvertAndCheckID(strid, max)
+	if id == 0 {
+		return 0, false
+	}
+
+	target := strings.TrimSuffix(r.URL.Path, strid) + stableIDOf(id)
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+	return id, true
+}
+
+func renderBuddies(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
+	renderPeople(w, "Buddies", "buddies", divelog.index.buddies)
+}
+
+func renderBuddy(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
+	renderPerson(w, r, "Dives with buddy", divelog.index.buddies)
+}
+
+func renderOperators(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
+	renderPeople(w, "Operators", "operators", divelog.index.operators)
+}
+
+func renderOperator(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
+	renderPerson(w, r, "Dives with operator", divelog.index.operators)
+}
+
+func renderBuddyGraph(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
+	renderTemplate(w, Page{
+		Title:      "Who dived with whom",
+		Supertitle: "Buddies",
+		BuddyGraph: NewBuddyGraph(divelog),
+	})
+}
+
+func renderGear(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
+	renderTemplate(w, Page{
+		Title:      "Gear",
+		Supertitle: "All",
+		Gear:       GroupGear(divelog.index.gear),
+	})
+}
+
+func renderGearService(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
+	renderTemplate(w, Page{
+		Title:      "Service",
+		Supertitle: "Gear",
+		GearService: &GearServiceReport{
+			Configured: divelog.gearConfig != nil,
+			Items:      CheckServiceIntervals(divelog, time.Now().UTC()),
+		},
+	})
+}
+
+func renderGearItem(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
+	item := FindGearItem(divelog.index.gear, r.PathValue("kind"), utils.Slug(r.PathValue("name")))
+	if item == nil {
+		renderNotFound(w, "gear not found")
+		return
+	}
+
+	renderTemplate(w, Page{
+		Title:      item.Name,
+		Supertitle: gearKindTitle(item.Kind),
+		GearItem:   item,
+	})
+}
+
+func renderTraining(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
+	renderTemplate(w, Page{
+		Title:      "Training",
+		Supertitle: "Certifications and courses",
+		Training:   NewTrainingRecord(divelog),
+	})
+}
+
+func renderChanges(w http.ResponseWriter, r *http.Request) {
+	renderTemplate(w, Page{
+		Title:      "Changes",
+		Supertitle: "Recent",
+		Changes:    &ChangeLog{ChangeSets: changeSets()},
+	})
+}
+
+func renderPeople(w http.ResponseWriter, title string, kind string, people []*PersonFull) {
+	directory := &PeopleDirectory{
+		Kind:   kind,
+		People: make([]*Person, 0, len(people)),
+	}
+	for _, person := range people {
+		directory.People = append(directory.People, person.Person)
+	}
+
+	renderTemplate(w, Page{
+		Title:      title,
+		Supertitle: "All",
+		People:     directory,
+	})
+}
+
+func renderPerson(w http.ResponseWriter, r *http.Request, supertitle string, people []*PersonFull) {
+	person := findPerson(r, people)
+	if person == nil {
+		renderNotFound(w, "person not found")
+		return
+	}
+
+	renderTemplate(w, Page{
+		Title:      person.Name,
+		Supertitle: supertitle,
+		Person:     person,
+	})
+}
+
+func findPerson(r *http.Request, people []*PersonFull) *PersonFull {
+	slug := utils.Slug(r.PathValue("name"))
+	for _, person := range people {
+		if person.Slug == slug {
+			return person
+		}
+	}
+	return nil
+}
+
 func renderNotFound(w http.ResponseWriter, title string) {
 	if title == "" {
 		title = "not found"
@@ -385,6 +705,7 @@ func renderTemplate(w http.ResponseWriter, p Page) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	w.Header().Set("Content-Type", ContentTypeHTML)
 	if err := _page_template.Execute(w, p); err != nil {
 		trace(_error, "http: render template: %v", err)
 	}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"slices"
+	"sort"
+	"time"
+)
+
+// The index of a snapshot is computed once, when the snapshot is built or loaded from the cache,
+// so that handlers do not scan all dives for every trip, site or tag they serve.
+// Like the snapshot, the index must not be modified once the snapshot is published.
+
+type diveIndex struct {
+	// dive heads by dive ID, shared by all lists of dives
+	heads []*DiveHead
+
+	// dives in file order, by trip ID and by site ID
+	byTrip [][]*Dive
+	bySite [][]*Dive
+
+	// dives in file order by tag, and the number of uses of each tag
+	byTag     map[string][]*Dive
+	tagCounts map[string]int
+
+	// dives in file order by year, and the years in ascending order
+	byYear map[int][]*Dive
+	years  []int
+
+	// sorted by name, gear also by kind
+	sitesByName []*DiveSite
+	buddies     []*PersonFull
+	operators   []*PersonFull
+	gear        []*GearItemFull
+
+	// slug by name, for links to people
+	buddySlugs    map[string]string
+	operatorSlugs map[string]string
+}
+
+// Slugs of routes next to the pages of buddies, e.g. /hms/buddies/graph.
+var _reserved_buddy_slugs = []string{"graph"}
+
+// newDiveIndex indexes the dive log, once it is fully built.
+func newDiveIndex(divelog *DiveLog) *diveIndex {
+	start := time.Now()
+	index := &diveIndex{
+		heads:     make([]*DiveHead, len(divelog.Dives)),
+		byTrip:    make([][]*Dive, len(divelog.DiveTrips)),
+		bySite:    make([][]*Dive, len(divelog.DiveSites)),
+		byTag:     make(map[string][]*Dive),
+		tagCounts: make(map[string]int),
+		byYear:    make(map[int][]*Dive),
+	}
+
+	for _, dive := range divelog.Dives[1:] {
+		index.heads[dive.ID] = NewDiveHead(dive, divelog.DiveSites[dive.DiveSiteID])
+		index.byTrip[dive.DiveTripID] = append(index.byTrip[dive.DiveTripID], dive)
+		index.bySite[dive.DiveSiteID] = append(index.bySite[dive.DiveSiteID], dive)
+
+		for i, tag := range dive.Tags {
+			index.tagCounts[tag]++
+			// a tag listed twice for a dive lists the dive once
+			if !slices.Contains(dive.Tags[:i], tag) {
+				index.byTag[tag] = append(index.byTag[tag], dive)
+			}
+		}
+
+		year := dive.datetime.Year()
+		if _, ok := index.byYear[year]; !ok {
+			index.years = append(index.years, year)
+		}
+		index.byYear[year] = append(index.byYear[year], dive)
+	}
+	sort.Ints(index.years)
+
+	index.sitesByName = append(index.sitesByName, divelog.DiveSites[1:]...)
+	sort.SliceStable(index.sitesByName, func(i, j int) bool {
+		return index.sitesByName[i].Name < index.sitesByName[j].Name
+	})
+
+	index.buddies, index.buddySlugs = CollectPeople(divelog, (*Dive).Buddies, _reserved_buddy_slugs...)
+	index.operators, index.operatorSlugs = CollectPeople(divelog, (*Dive).Operators)
+	index.gear = CollectGear(divelog)
+
+	trace(_build, "dive log indexed in %s: %d tags, %d years, %d buddies, %d operators",
+		time.Since(start), len(index.byTag), len(index.years), len(index.buddies), len(index.operators))
+	return index
+}
+
+// diveHeads returns the heads of the dives, in reverse order if reverse is true, or nil if there are none.
+func (index *diveIndex) diveHeads(dives []*Dive, reverse bool) []*DiveHead {
+	if len(dives) == 0 {
+		return nil
+	}
+	heads := make([]*DiveHead, 0, len(dives))
+	for i := range dives {
+		if reverse {
+			heads = append(heads, index.heads[dives[len(dives)-1-i].ID])
+		} else {
+			heads = append(heads, index.heads[dives[i].ID])
+		}
+	}
+	return heads
+}
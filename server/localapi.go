@@ -25,6 +25,40 @@ func forceFailure(w http.ResponseWriter, r *http.Request) {
 	assert(false, "forced failure")
 }
 
+// rebuildDatabase triggers a rebuild, and if the wait query parameter is set,
+// responds with the build status once the rebuild is completed.
 func rebuildDatabase(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusNotImplemented)
+	done := requestRebuild()
+	if r.URL.Query().Get("wait") != "true" {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	select {
+	case <-done:
+	case <-r.Context().Done():
+		return
+	}
+
+	status := NewBuildStatus(acquireDataAccess(), _last_build_attempt.Load())
+	code := http.StatusOK
+	if status.LastError != "" {
+		code = http.StatusInternalServerError
+	}
+	sendBuildStatus(w, status, code)
+}
+
+func fetchBuildStatus(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
+	sendBuildStatus(w, NewBuildStatus(divelog, _last_build_attempt.Load()), http.StatusOK)
+}
+
+func sendBuildStatus(w http.ResponseWriter, status *BuildStatus, code int) {
+	encoded, err := json.Marshal(status)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	send(w, encoded)
 }
@@ -1,43 +1,160 @@
 package server
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+)
+
 const (
-	UnlabeledRegion            = "Unlabeled Region"
-	UndefinedDescription       = "This dive site is missing a description."
-	PrefixForTagsInDescription = "tags:"
-	RegionTagPrefix            = "_region_"
+	UnlabeledRegion      = "Unlabeled Region"
+	UndefinedDescription = "This dive site is missing a description."
+	MappingsFileName     = "mappings.json"
+)
+
+// Mappings control how values found in the source file are interpreted.
+// The defaults can be overridden by a mappings file in the watch directory.
+type Mappings struct {
+	PrefixForTagsInDescription string            `json:"prefix_for_tags_in_description"`
+	RegionTagPrefix            string            `json:"region_tag_prefix"`
+	CylinderTypes              map[string]string `json:"cylinder_types"`
+	SpecialTagValues           map[string]string `json:"special_tag_values"`
+	Awards                     map[string]string `json:"awards"`
+}
+
+var _default_mappings = &Mappings{
+	PrefixForTagsInDescription: "tags:",
+	RegionTagPrefix:            "_region_",
+	CylinderTypes: map[string]string{
+		"AL100": "aluminium",
+		"HP100": "steel",
+		"HP130": "steel",
+	},
+	SpecialTagValues: map[string]string{
+		"europe":        "Europe",
+		"asia":          "Asia",
+		"north-america": "North America",
+		"atlantic":      "Atlantic Ocean",
+		"indian":        "Indian Ocean",
+		"pacific":       "Pacific Ocean",
+		"mediterranean": "Mediterranean Sea",
+		"red-sea":       "Red Sea",
+	},
+	Awards: map[string]string{
+		"1st-dive":              "First dive!",
+		"1st-seawater-dive":     "First seawater dive!",
+		"1st-shark-encounter":   "First shark encounter!",
+		"1st-night-dive":        "First night dive!",
+		"1st-nitrox-dive":       "First nitrox dive!",
+		"1st-30m-dive":          "First 30m dive!",
+		"1st-40m-dive":          "First 40m dive!",
+		"1st-wreck-dive":        "First wreck dive!",
+		"1st-wreck-penetration": "First wreck penetration dive!",
+		"100th-dive":            "100th dive!",
+	},
+}
+
+// Only builder() accesses these, see _divelog.
+var (
+	_mappings         = _default_mappings
+	_mappings_modTime time.Time
 )
 
-var CylinderTypeMappings = map[string]string{
-	"AL100": "aluminium",
-	"HP100": "steel",
-	"HP130": "steel",
+// LoadMappings reads and validates a mappings file. Settings missing from the
+// file keep their default values, and all problems are reported together.
+func LoadMappings(path string) (*Mappings, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	mappings := &Mappings{}
+	if err := json.Unmarshal(data, mappings); err != nil {
+		return nil, fmt.Errorf("failed to parse mappings %s: %v", path, err)
+	}
+
+	if mappings.PrefixForTagsInDescription == "" {
+		mappings.PrefixForTagsInDescription = _default_mappings.PrefixForTagsInDescription
+	}
+	if mappings.RegionTagPrefix == "" {
+		mappings.RegionTagPrefix = _default_mappings.RegionTagPrefix
+	}
+	if mappings.CylinderTypes == nil {
+		mappings.CylinderTypes = _default_mappings.CylinderTypes
+	}
+	if mappings.SpecialTagValues == nil {
+		mappings.SpecialTagValues = _default_mappings.SpecialTagValues
+	}
+	if mappings.Awards == nil {
+		mappings.Awards = _default_mappings.Awards
+	}
+
+	var problems []error
+	if strings.ContainsFunc(mappings.PrefixForTagsInDescription, unicode.IsSpace) {
+		problems = append(problems, fmt.Errorf("prefix_for_tags_in_description %q must not contain whitespace", mappings.PrefixForTagsInDescription))
+	}
+	if !strings.HasPrefix(mappings.RegionTagPrefix, "_") || !strings.HasSuffix(mappings.RegionTagPrefix, "_") ||
+		strings.ContainsFunc(mappings.RegionTagPrefix, unicode.IsSpace) {
+		problems = append(problems, fmt.Errorf("region_tag_prefix %q must start and end with '_' and must not contain whitespace", mappings.RegionTagPrefix))
+	}
+	problems = append(problems, validateMapping("cylinder_types", mappings.CylinderTypes)...)
+	problems = append(problems, validateMapping("special_tag_values", mappings.SpecialTagValues)...)
+	problems = append(problems, validateMapping("awards", mappings.Awards)...)
+
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid mappings %s: %w", path, errors.Join(problems...))
+	}
+	return mappings, nil
 }
 
-var SpecialTagValueMappings = map[string]string{
-	"europe":        "Europe",
-	"asia":          "Asia",
-	"north-america": "North America",
-	"atlantic":      "Atlantic Ocean",
-	"indian":        "Indian Ocean",
-	"pacific":       "Pacific Ocean",
-	"mediterranean": "Mediterranean Sea",
-	"red-sea":       "Red Sea",
+func validateMapping(name string, mapping map[string]string) (problems []error) {
+	for key, value := range mapping {
+		if key == "" || strings.ContainsFunc(key, unicode.IsSpace) {
+			problems = append(problems, fmt.Errorf("%s: key %q must be non-empty and must not contain whitespace", name, key))
+		}
+		if strings.TrimSpace(value) == "" {
+			problems = append(problems, fmt.Errorf("%s: value for key %q is empty", name, key))
+		}
+	}
+	return
 }
 
-var AwardMappings = map[string]string{
-	"1st-dive":              "First dive!",
-	"1st-seawater-dive":     "First seawater dive!",
-	"1st-shark-encounter":   "First shark encounter!",
-	"1st-night-dive":        "First night dive!",
-	"1st-30m-dive":          "First 30m dive!",
-	"1st-40m-dive":          "First 40m dive!",
-	"1st-wreck-dive":        "First wreck dive!",
-	"1st-wreck-penetration": "First wreck penetration dive!",
-	"cert-owd":              "OWD diver! (CMAS)",
-	"cert-aowd-nitrox":      "AOWD diver! Nitrox specialty diver! (SSI)",
-	"cert-navigation":       "Navigation specialty diver! (SSI)",
-	"cert-dry":              "Dry suit specialty diver! (SSI)",
-	"cert-deep":             "Deep specialty diver! (PADI)",
-	"cert-wreck":            "Wreck specialty diver! (PADI)",
-	"100th-dive":            "100th dive!",
+// reloadMappings checks the mappings file in the watch directory, and reloads it
+// if it changed since the last check. An invalid file is reported and ignored, so
+// the mappings in use remain unchanged. Returns true if the mappings in use changed.
+func reloadMappings() bool {
+	path := filepath.Join(_control_block.watchDirectoryPath, MappingsFileName)
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		if _mappings == _default_mappings {
+			return false
+		}
+		trace(_build, "mappings file %s removed, reverting to default mappings", path)
+		_mappings, _mappings_modTime = _default_mappings, time.Time{}
+		return true
+	}
+	if err != nil {
+		trace(_error, "failed to check mappings file: %v", err)
+		return false
+	}
+	if info.ModTime().Equal(_mappings_modTime) {
+		return false
+	}
+
+	// remember the modification time of invalid files too, to report them only once
+	_mappings_modTime = info.ModTime()
+	mappings, err := LoadMappings(path)
+	if err != nil {
+		trace(_error, "keeping mappings in use: %v", err)
+		return false
+	}
+
+	_mappings = mappings
+	trace(_build, "mappings reloaded from %s", path)
+	return true
 }
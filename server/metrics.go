@@ -0,0 +1,283 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Health, readiness and metrics endpoints for monitoring. Metrics are served in the
+// Prometheus text exposition format, written here to avoid a dependency on the client library.
+// Requests are labeled by the pattern of the handler which served them, to bound the number of series.
+
+const ContentTypeMetrics = "text/plain; version=0.0.4; charset=utf-8"
+
+// in seconds
+var (
+	_request_buckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+	_build_buckets   = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+)
+
+var _process_start = time.Now()
+
+type histogram struct {
+	buckets []float64
+	counts  []uint64 // per bucket, not cumulative; the last one counts values above all buckets
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets)+1)}
+}
+
+func (h *histogram) observe(value float64) {
+	h.counts[sort.SearchFloat64s(h.buckets, value)]++
+	h.sum += value
+	h.count++
+}
+
+type requestSeries struct {
+	route string
+	code  int
+}
+
+var _metrics = struct {
+	sync.Mutex
+	requests      map[requestSeries]*histogram
+	builds        *histogram
+	buildFailures uint64
+}{
+	requests: make(map[requestSeries]*histogram),
+	builds:   newHistogram(_build_buckets),
+}
+
+var _response_cache_hits, _response_cache_misses atomic.Uint64
+
+func observeRequest(route string, code int, elapsed time.Duration) {
+	m := &_metrics
+	m.Lock()
+	defer m.Unlock()
+	series := requestSeries{route: route, code: code}
+	h, ok := m.requests[series]
+	if !ok {
+		h = newHistogram(_request_buckets)
+		m.requests[series] = h
+	}
+	h.observe(elapsed.Seconds())
+}
+
+// observeBuild records the duration of a successful build.
+func observeBuild(elapsed time.Duration) {
+	m := &_metrics
+	m.Lock()
+	defer m.Unlock()
+	m.builds.observe(elapsed.Seconds())
+}
+
+// observeBuildFailure records a builder iteration which failed to build a newer data file.
+func observeBuildFailure() {
+	m := &_metrics
+	m.Lock()
+	defer m.Unlock()
+	m.buildFailures++
+}
+
+// Measure returns an adapter that records the number and latency of requests,
+// by the route returned by routeOf and by status code.
+func Measure(routeOf func(r *http.Request) string) Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			sw := &statusWriter{ResponseWriter: w}
+			h.ServeHTTP(sw, r)
+			if sw.status == 0 {
+				sw.status = http.StatusOK
+			}
+			observeRequest(routeOf(r), sw.status, time.Since(start))
+		})
+	}
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	return sw.ResponseWriter.Write(b)
+}
+
+// routeOf returns the pattern of the handler of the multiplexer which serves the request.
+func routeOf(mux *http.ServeMux) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		if _, pattern := mux.Handler(r); pattern != "" {
+			return pattern
+		}
+		return "unmatched"
+	}
+}
+
+// readiness returns an empty string if a snapshot is served, and its data file is recent enough.
+func readiness(divelog *DiveLog, now time.Time) string {
+	if divelog == nil {
+		return "no snapshot loaded"
+	}
+	maxAge := _control_block.maxSnapshotAge
+	if age := now.Sub(divelog.Metadata.modTime); maxAge > 0 && age > maxAge {
+		return fmt.Sprintf("data file of snapshot %d is %s old, more than %s", divelog.Metadata.generation, age.Round(time.Second), maxAge)
+	}
+	return ""
+}
+
+func healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, "ok\n")
+}
+
+func readyz(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if reason := readiness(divelog, time.Now()); reason != "" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		io.WriteString(w, reason+"\n")
+		return
+	}
+	io.WriteString(w, "ready\n")
+}
+
+func fetchMetrics(w http.ResponseWriter, r *http.Request, divelog *DiveLog) {
+	w.Header().Set("Content-Type", ContentTypeMetrics)
+	buffered := bufio.NewWriter(w)
+	writeMetrics(buffered, divelog, time.Now())
+	if err := buffered.Flush(); err != nil {
+		traceContext(r.Context(), _error, "http: send: %v", err)
+	}
+}
+
+func writeMetrics(w io.Writer, divelog *DiveLog, now time.Time) {
+	m := &_metrics
+	m.Lock()
+	series := make([]requestSeries, 0, len(m.requests))
+	for s := range m.requests {
+		series = append(series, s)
+	}
+	sort.Slice(series, func(i, j int) bool {
+		if series[i].route != series[j].route {
+			return series[i].route < series[j].route
+		}
+		return series[i].code < series[j].code
+	})
+
+	writeHeader(w, "bluefin_http_requests_total", "counter", "Number of HTTP requests by route and status code.")
+	for _, s := range series {
+		fmt.Fprintf(w, "bluefin_http_requests_total{route=%s,code=\"%d\"} %d\n", quoteLabel(s.route), s.code, m.requests[s].count)
+	}
+	writeHeader(w, "bluefin_http_request_duration_seconds", "histogram", "Latency of HTTP requests by route and status code.")
+	for _, s := range series {
+		writeHistogram(w, "bluefin_http_request_duration_seconds", fmt.Sprintf("route=%s,code=\"%d\"", quoteLabel(s.route), s.code), m.requests[s])
+	}
+	writeHeader(w, "bluefin_build_duration_seconds", "histogram", "Duration of successful builds, from reading the data file to indexing the dive log.")
+	writeHistogram(w, "bluefin_build_duration_seconds", "", m.builds)
+	writeHeader(w, "bluefin_build_failures_total", "counter", "Number of builder iterations which failed to build a newer data file.")
+	fmt.Fprintf(w, "bluefin_build_failures_total %d\n", m.buildFailures)
+	m.Unlock()
+
+	if attempt := _last_build_attempt.Load(); attempt != nil {
+		writeHeader(w, "bluefin_last_build_attempt_timestamp_seconds", "gauge", "Time of the last builder iteration.")
+		fmt.Fprintf(w, "bluefin_last_build_attempt_timestamp_seconds %d\n", attempt.time.Unix())
+		writeHeader(w, "bluefin_last_build_failed", "gauge", "Whether the last builder iteration failed.")
+		fmt.Fprintf(w, "bluefin_last_build_failed %d\n", boolValue(attempt.err != nil))
+	}
+
+	if divelog != nil {
+		_, rolledBack := snapshotHistory()
+		writeGauge(w, "bluefin_snapshot_generation", "Generation of the served snapshot.", float64(divelog.Metadata.generation))
+		writeGauge(w, "bluefin_snapshot_age_seconds", "Time since the served snapshot was built.", now.Sub(divelog.Metadata.builtAt).Seconds())
+		writeGauge(w, "bluefin_snapshot_data_age_seconds", "Time since the data file of the served snapshot was modified.", now.Sub(divelog.Metadata.modTime).Seconds())
+		writeGauge(w, "bluefin_snapshot_rolled_back", "Whether the served snapshot is rolled back.", float64(boolValue(rolledBack)))
+		writeGauge(w, "bluefin_dives", "Number of dives in the served snapshot.", float64(len(divelog.Dives)-1))
+		writeGauge(w, "bluefin_dive_sites", "Number of dive sites in the served snapshot.", float64(len(divelog.DiveSites)-1))
+		writeGauge(w, "bluefin_dive_trips", "Number of dive trips in the served snapshot.", float64(len(divelog.DiveTrips)-1))
+		writeGauge(w, "bluefin_validation_issues", "Number of data problems in the served snapshot.", float64(len(divelog.issues)))
+	}
+
+	writeHeader(w, "bluefin_response_cache_lookups_total", "counter", "Number of response cache lookups by result.")
+	fmt.Fprintf(w, "bluefin_response_cache_lookups_total{result=\"hit\"} %d\n", _response_cache_hits.Load())
+	fmt.Fprintf(w, "bluefin_response_cache_lookups_total{result=\"miss\"} %d\n", _response_cache_misses.Load())
+	writeGauge(w, "bluefin_response_cache_bytes", "Size of the cached responses.", float64(responseCacheSize()))
+
+	var memstats runtime.MemStats
+	runtime.ReadMemStats(&memstats)
+	writeHeader(w, "go_info", "gauge", "Information about the Go environment.")
+	fmt.Fprintf(w, "go_info{version=%s} 1\n", quoteLabel(runtime.Version()))
+	writeGauge(w, "go_goroutines", "Number of goroutines that currently exist.", float64(runtime.NumGoroutine()))
+	writeGauge(w, "go_memstats_alloc_bytes", "Number of bytes allocated and still in use.", float64(memstats.Alloc))
+	writeGauge(w, "go_memstats_heap_inuse_bytes", "Number of heap bytes that are in use.", float64(memstats.HeapInuse))
+	writeGauge(w, "go_memstats_sys_bytes", "Number of bytes obtained from the system.", float64(memstats.Sys))
+	writeHeader(w, "go_gc_cycles_total", "counter", "Number of completed GC cycles.")
+	fmt.Fprintf(w, "go_gc_cycles_total %d\n", memstats.NumGC)
+	writeHeader(w, "go_gc_pause_seconds_total", "counter", "Total time spent in GC stop-the-world pauses.")
+	fmt.Fprintf(w, "go_gc_pause_seconds_total %s\n", formatFloat(float64(memstats.PauseTotalNs)/1e9))
+	writeGauge(w, "process_start_time_seconds", "Start time of the process since the Unix epoch.", float64(_process_start.Unix()))
+}
+
+func writeHeader(w io.Writer, name string, kind string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeGauge(w io.Writer, name string, help string, value float64) {
+	writeHeader(w, name, "gauge", help)
+	fmt.Fprintf(w, "%s %s\n", name, formatFloat(value))
+}
+
+func writeHistogram(w io.Writer, name string, labels string, h *histogram) {
+	if labels != "" {
+		labels += ","
+	}
+	var cumulative uint64
+	for i, bound := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d\n", name, labels, formatFloat(bound), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, labels, h.count)
+	labels = strings.TrimSuffix(labels, ",")
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count)
+}
+
+func quoteLabel(value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+	return `"` + value + `"`
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func boolValue(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
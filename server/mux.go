@@ -1,11 +1,16 @@
 package server
 
-import "net/http"
+import (
+	"html/template"
+	"net/http"
+)
 
 func multiplexer() http.Handler {
+	_page_template = template.Must(template.ParseFiles(FilePageTemplate))
+
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("GET /hms/dives", funcWithDataAccess(renderDives))
+	mux.HandleFunc("GET /hms/dives", funcWithCachedResponse(renderDives))
 	trace(_https, "handler registered for /hms/dives")
 
 	mux.HandleFunc("GET /hms/dives/{$}", func(w http.ResponseWriter, r *http.Request) {
@@ -13,7 +18,15 @@ func multiplexer() http.Handler {
 	})
 	trace(_https, "handler registered for /hms/dives/")
 
-	mux.HandleFunc("GET /hms/sites", funcWithDataAccess(renderSites))
+	mux.HandleFunc("GET /hms/trips", funcWithCachedResponse(renderTrips))
+	trace(_https, "handler registered for /hms/trips")
+
+	mux.HandleFunc("GET /hms/trips/{$}", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/hms/trips", http.StatusMovedPermanently)
+	})
+	trace(_https, "handler registered for /hms/trips/")
+
+	mux.HandleFunc("GET /hms/sites", funcWithCachedResponse(renderSites))
 	trace(_https, "handler registered for /hms/sites")
 
 	mux.HandleFunc("GET /hms/sites/{$}", func(w http.ResponseWriter, r *http.Request) {
@@ -21,7 +34,7 @@ func multiplexer() http.Handler {
 	})
 	trace(_https, "handler registered for /hms/sites/")
 
-	mux.HandleFunc("GET /hms/tags", funcWithDataAccess(renderTags))
+	mux.HandleFunc("GET /hms/tags", funcWithCachedResponse(renderTags))
 	trace(_https, "handler registered for /hms/tags")
 
 	mux.HandleFunc("GET /hms/tags/{$}", func(w http.ResponseWriter, r *http.Request) {
@@ -29,22 +42,71 @@ func multiplexer() http.Handler {
 	})
 	trace(_https, "handler registered for /hms/tags/")
 
-	mux.HandleFunc("GET /hms/dives/{id}", funcWithDataAccess(renderDive))
+	mux.HandleFunc("GET /hms/buddies", funcWithCachedResponse(renderBuddies))
+	trace(_https, "handler registered for /hms/buddies")
+
+	mux.HandleFunc("GET /hms/buddies/{$}", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/hms/buddies", http.StatusMovedPermanently)
+	})
+	trace(_https, "handler registered for /hms/buddies/")
+
+	mux.HandleFunc("GET /hms/operators", funcWithCachedResponse(renderOperators))
+	trace(_https, "handler registered for /hms/operators")
+
+	mux.HandleFunc("GET /hms/operators/{$}", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/hms/operators", http.StatusMovedPermanently)
+	})
+	trace(_https, "handler registered for /hms/operators/")
+
+	mux.HandleFunc("GET /hms/gear", funcWithCachedResponse(renderGear))
+	trace(_https, "handler registered for /hms/gear")
+
+	mux.HandleFunc("GET /hms/gear/{$}", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/hms/gear", http.StatusMovedPermanently)
+	})
+	trace(_https, "handler registered for /hms/gear/")
+
+	mux.HandleFunc("GET /hms/training", funcWithCachedResponse(renderTraining))
+	trace(_https, "handler registered for /hms/training")
+
+	mux.HandleFunc("GET /hms/dives/{id}", funcWithCachedResponse(renderDive))
 	trace(_https, "handler registered for /hms/dives/{id}")
 
-	mux.HandleFunc("GET /hms/sites/{id}", funcWithDataAccess(renderSite))
+	mux.HandleFunc("GET /hms/trips/{id}", funcWithCachedResponse(renderTrip))
+	trace(_https, "handler registered for /hms/trips/{id}")
+
+	mux.HandleFunc("GET /hms/sites/{id}", funcWithCachedResponse(renderSite))
 	trace(_https, "handler registered for /hms/sites/{id}")
 
-	mux.HandleFunc("GET /hms/tags/{tag}", funcWithDataAccess(renderTaggedDives))
+	mux.HandleFunc("GET /hms/tags/{tag}", funcWithCachedResponse(renderTaggedDives))
 	trace(_https, "handler registered for /hms/tags/{tag}")
 
-	mux.HandleFunc("GET /hms/about", func(w http.ResponseWriter, r *http.Request) {
+	// the literal segment takes precedence over {name}, and no buddy has the slug graph
+	mux.HandleFunc("GET /hms/buddies/graph", funcWithCachedResponse(renderBuddyGraph))
+	trace(_https, "handler registered for /hms/buddies/graph")
+
+	mux.HandleFunc("GET /hms/buddies/{name}", funcWithCachedResponse(renderBuddy))
+	trace(_https, "handler registered for /hms/buddies/{name}")
+
+	mux.HandleFunc("GET /hms/operators/{name}", funcWithCachedResponse(renderOperator))
+	trace(_https, "handler registered for /hms/operators/{name}")
+
+	mux.HandleFunc("GET /hms/gear/service", funcWithCachedResponse(renderGearService))
+	trace(_https, "handler registered for /hms/gear/service")
+
+	mux.HandleFunc("GET /hms/gear/{kind}/{name}", funcWithCachedResponse(renderGearItem))
+	trace(_https, "handler registered for /hms/gear/{kind}/{name}")
+
+	mux.HandleFunc("GET /hms/changes", funcWithValidators(CacheControlRevalidate, renderChanges))
+	trace(_https, "handler registered for /hms/changes")
+
+	mux.HandleFunc("GET /hms/about", funcWithValidators(CacheControlSnapshot, func(w http.ResponseWriter, r *http.Request) {
 		renderTemplate(w, Page{
 			Title:      "this site",
 			Supertitle: "about",
 			About:      true,
 		})
-	})
+	}))
 	trace(_https, "handler registered for /hms/about")
 
 	// data handlers
@@ -53,28 +115,67 @@ func multiplexer() http.Handler {
 	})
 	trace(_https, "handler registered for /data/")
 
-	mux.HandleFunc("GET /data/sites", funcWithDataAccess(fetchSites))
+	mux.HandleFunc("GET /data/sites", funcWithCachedResponse(fetchSites))
 	trace(_https, "handler registered for /data/sites")
 	// DEVNOTE: /data/sites/{$} returns 404
 
-	mux.HandleFunc("GET /data/sites/{id}", funcWithDataAccess(fetchSite))
+	mux.HandleFunc("GET /data/sites/{id}", funcWithCachedResponse(fetchSite))
 	trace(_https, "handler registered for /data/sites/{id}")
 
-	mux.HandleFunc("GET /data/trips", funcWithDataAccess(fetchTrips))
+	mux.HandleFunc("GET /data/trips", funcWithCachedResponse(fetchTrips))
 	trace(_https, "handler registered for /data/trips")
 	// DEVNOTE: /data/trips/{$} returns 404
 
-	mux.HandleFunc("GET /data/dives", funcWithDataAccess(fetchDives))
+	mux.HandleFunc("GET /data/trips/{id}", funcWithCachedResponse(fetchTrip))
+	trace(_https, "handler registered for /data/trips/{id}")
+
+	mux.HandleFunc("GET /data/dives", funcWithCachedResponse(fetchDives))
 	trace(_https, "handler registered for /data/dives")
 	// DEVNOTE: /data/dives/{$} returns 404
 
-	mux.HandleFunc("GET /data/dives/{id}", funcWithDataAccess(fetchDive))
+	mux.HandleFunc("GET /data/dives/{id}", funcWithCachedResponse(fetchDive))
 	trace(_https, "handler registered for /data/dives/{id}")
 
-	mux.HandleFunc("GET /data/tags", funcWithDataAccess(fetchTags))
+	mux.HandleFunc("GET /data/tags", funcWithCachedResponse(fetchTags))
 	trace(_https, "handler registered for /data/tags")
 	// DEVNOTE: /data/tags/{$} returns 404
 
+	mux.HandleFunc("GET /data/buddies", funcWithCachedResponse(fetchBuddies))
+	trace(_https, "handler registered for /data/buddies")
+	// DEVNOTE: /data/buddies/{$} returns 404
+
+	mux.HandleFunc("GET /data/buddies/graph", funcWithCachedResponse(fetchBuddyGraph))
+	trace(_https, "handler registered for /data/buddies/graph")
+
+	mux.HandleFunc("GET /data/buddies/{name}", funcWithCachedResponse(fetchBuddy))
+	trace(_https, "handler registered for /data/buddies/{name}")
+
+	mux.HandleFunc("GET /data/operators", funcWithCachedResponse(fetchOperators))
+	trace(_https, "handler registered for /data/operators")
+	// DEVNOTE: /data/operators/{$} returns 404
+
+	mux.HandleFunc("GET /data/operators/{name}", funcWithCachedResponse(fetchOperator))
+	trace(_https, "handler registered for /data/operators/{name}")
+
+	mux.HandleFunc("GET /data/gear", funcWithCachedResponse(fetchGear))
+	trace(_https, "handler registered for /data/gear")
+	// DEVNOTE: /data/gear/{$} returns 404
+
+	mux.HandleFunc("GET /data/gear/service", funcWithCachedResponse(fetchGearService))
+	trace(_https, "handler registered for /data/gear/service")
+
+	mux.HandleFunc("GET /data/gear/{kind}/{name}", funcWithCachedResponse(fetchGearItem))
+	trace(_https, "handler registered for /data/gear/{kind}/{name}")
+
+	mux.HandleFunc("GET /data/training", funcWithCachedResponse(fetchTraining))
+	trace(_https, "handler registered for /data/training")
+
+	mux.HandleFunc("GET /data/changes", funcWithValidators(CacheControlRevalidate, fetchChanges))
+	trace(_https, "handler registered for /data/changes")
+
+	mux.HandleFunc("GET /data/validation", funcWithCachedResponse(fetchValidation))
+	trace(_https, "handler registered for /data/validation")
+
 	mux.HandleFunc("GET /", defaultHandler)
 	trace(_https, "handler registered for /")
 
@@ -83,17 +184,47 @@ func multiplexer() http.Handler {
 	})
 	trace(_https, "handler registered for /{$}")
 
+	// monitoring handlers
+	mux.Handle("GET /healthz", Adapt(http.HandlerFunc(healthz), CacheControl(CacheControlNoStore)))
+	trace(_https, "handler registered for /healthz")
+
+	mux.Handle("GET /readyz", Adapt(funcWithDataAccess(readyz), CacheControl(CacheControlNoStore)))
+	trace(_https, "handler registered for /readyz")
+
+	if _control_block.metrics {
+		mux.Handle("GET /metrics", Adapt(funcWithDataAccess(fetchMetrics), CacheControl(CacheControlNoStore)))
+		trace(_https, "handler registered for /metrics")
+	}
+
 	// local API handlers
 	if _control_block.localAPI {
-		mux.HandleFunc("GET /data/0", funcWithDataAccess(fetchAll))
+		mux.Handle("GET /data/0", Adapt(funcWithDataAccess(fetchAll), CacheControl(CacheControlNoStore)))
 		trace(_https, "handler registered for /data/0")
 
 		mux.HandleFunc("POST /action/fail", forceFailure)
 		trace(_https, "handler registered for /action/fail")
 
-		mux.HandleFunc("POST /action/rebuild", rebuildDatabase)
+		mux.Handle("POST /action/rebuild", Adapt(http.HandlerFunc(rebuildDatabase), CacheControl(CacheControlNoStore)))
 		trace(_https, "handler registered for /action/rebuild")
+
+		mux.Handle("GET /action/build-status", Adapt(funcWithDataAccess(fetchBuildStatus), CacheControl(CacheControlNoStore)))
+		trace(_https, "handler registered for /action/build-status")
+	}
+
+	// admin API handlers
+	if token := _control_block.adminToken; token != "" {
+		mux.Handle("GET /action/snapshots", Adapt(funcWithDataAccess(fetchSnapshots), RequireToken(token), CacheControl(CacheControlNoStore)))
+		trace(_https, "handler registered for /action/snapshots")
+
+		mux.Handle("POST /action/snapshots/{generation}/rollback", Adapt(http.HandlerFunc(rollbackToSnapshot), RequireToken(token), CacheControl(CacheControlNoStore)))
+		trace(_https, "handler registered for /action/snapshots/{generation}/rollback")
+
+		mux.Handle("GET /action/logging", Adapt(http.HandlerFunc(fetchLogSettings), RequireToken(token), CacheControl(CacheControlNoStore)))
+		trace(_https, "handler registered for /action/logging")
+
+		mux.Handle("PUT /action/logging", Adapt(http.HandlerFunc(updateLogSettings), RequireToken(token), CacheControl(CacheControlNoStore)))
+		trace(_https, "handler registered for PUT /action/logging")
 	}
 
-	return mux
+	return Adapt(mux, Compress(), LogRequests(), Measure(routeOf(mux)))
 }
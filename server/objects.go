@@ -2,8 +2,13 @@ package server
 
 import (
 	"fmt"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
+
+	"src.acicovic.me/divelog/server/utils"
 )
 
 type All struct {
@@ -13,8 +18,9 @@ type All struct {
 }
 
 type SiteHead struct {
-	ID   int    `json:"id"`
-	Name string `json:"name"`
+	ID       int    `json:"id"`
+	StableID string `json:"stable_id"`
+	Name     string `json:"name"`
 }
 
 type SiteFull struct {
@@ -24,6 +30,7 @@ type SiteFull struct {
 
 type DiveHead struct {
 	ID               int    `json:"id"`
+	StableID         string `json:"stable_id"`
 	ShortLabel       string `json:"short_label"`
 	DateTimeInPretty string `json:"date_time_in_pretty"`
 	Award            string `json:"award,omitempty"`
@@ -31,18 +38,73 @@ type DiveHead struct {
 
 type DiveFull struct {
 	*Dive
-	DiveSiteName     string `json:"dive_site_name"`
-	DateTimeInPretty string `json:"date_time_in_pretty"`
-	NextID           int    `json:"-"`
-	PrevID           int    `json:"-"`
+	DiveSiteName     string        `json:"dive_site_name"`
+	DiveSiteStableID string        `json:"dive_site_stable_id"`
+	DateTimeInPretty string        `json:"date_time_in_pretty"`
+	BuddyList        []*PersonHead `json:"buddy_list,omitempty"`
+	OperatorList     []*PersonHead `json:"operator_list,omitempty"`
+	NextStableID     string        `json:"-"`
+	PrevStableID     string        `json:"-"`
 }
 
 type Trip struct {
 	ID          int         `json:"id"`
+	StableID    string      `json:"stable_id"`
 	Label       string      `json:"label"`
 	LinkedDives []*DiveHead `json:"linked_dives"`
 }
 
+type TripFull struct {
+	*DiveTrip
+	DateFrom        string        `json:"date_from"`
+	DateTo          string        `json:"date_to"`
+	DateRangePretty string        `json:"date_range_pretty"`
+	DiveCount       int           `json:"dive_count"`
+	BottomTime      string        `json:"bottom_time"`
+	DepthMax        string        `json:"depth_max"`
+	Buddies         []*PersonHead `json:"buddies"`
+	Awards          []*DiveHead   `json:"awards"`
+	LinkedSites     []*TripSite   `json:"linked_sites"`
+	LinkedDives     []*DiveHead   `json:"linked_dives"`
+}
+
+type TripSite struct {
+	*SiteHead
+	Coordinates string `json:"coordinates,omitempty"`
+	DiveCount   int    `json:"dive_count"`
+}
+
+type PersonHead struct {
+	Slug string `json:"slug"`
+	Name string `json:"name"`
+}
+
+// Usage summarizes a group of dives, e.g. all dives with a buddy,
+// or all dives in which a piece of equipment was used.
+type Usage struct {
+	DiveCount  int       `json:"dive_count"`
+	BottomTime string    `json:"bottom_time"`
+	FirstDive  *DiveHead `json:"first_dive"`
+	LastDive   *DiveHead `json:"last_dive"`
+}
+
+type Person struct {
+	*PersonHead
+	*Usage
+}
+
+type PersonFull struct {
+	*Person
+	LinkedDives []*DiveHead `json:"linked_dives"`
+}
+
+// PeopleDirectory is either the buddy or the dive operator directory.
+// Kind is the path segment under which the directory is served.
+type PeopleDirectory struct {
+	Kind   string
+	People []*Person
+}
+
 type GroupedSites struct {
 	Region      string
 	LinkedSites []*SiteHead
@@ -51,33 +113,260 @@ type GroupedSites struct {
 func NewDiveHead(dive *Dive, diveSite *DiveSite) *DiveHead {
 	return &DiveHead{
 		ID:               dive.ID,
+		StableID:         dive.StableID,
 		ShortLabel:       fmt.Sprintf("Dive %d: %s", dive.Number, diveSite.ShortName()),
 		DateTimeInPretty: dive.datetime.Format("January 2 2006, 15:04"),
 		Award:            dive.Award,
 	}
 }
 
-func NewDiveFull(dive *Dive, diveSite *DiveSite) *DiveFull {
+func NewDiveFull(dive *Dive, divelog *DiveLog) *DiveFull {
+	diveSite := divelog.DiveSites[dive.DiveSiteID]
 	return &DiveFull{
 		Dive:             dive,
 		DiveSiteName:     diveSite.Name,
+		DiveSiteStableID: diveSite.StableID,
 		DateTimeInPretty: dive.datetime.Format("January 2 2006, 15:04"),
-		NextID:           dive.ID + 1,
-		PrevID:           dive.ID - 1,
+		BuddyList:        NewPersonHeads(dive.Buddies(), divelog.index.buddySlugs),
+		OperatorList:     NewPersonHeads(dive.Operators(), divelog.index.operatorSlugs),
 	}
 }
 
-func NewSiteFull(site *DiveSite, allDives []*Dive) *SiteFull {
-	s := &SiteFull{DiveSite: site}
-	for _, dive := range allDives {
-		if dive.DiveSiteID == site.ID {
-			s.LinkedDives = append(s.LinkedDives, NewDiveHead(dive, site))
+// NewPersonHeads returns the heads of the people, with slugs as assigned by CollectPeople.
+func NewPersonHeads(names []string, slugs map[string]string) []*PersonHead {
+	var heads []*PersonHead
+	for _, name := range names {
+		heads = append(heads, &PersonHead{
+			Slug: slugs[name],
+			Name: name,
+		})
+	}
+	return heads
+}
+
+// CollectPeople groups dives by the people returned by namesOf, and returns
+// the people sorted by slug, with the slug of each name. Dives of each person
+// are in reverse chronological order. People are not given the reserved slugs.
+func CollectPeople(divelog *DiveLog, namesOf func(*Dive) []string, reserved ...string) ([]*PersonFull, map[string]string) {
+	groups := groupDives(divelog, namesOf, reserved...)
+	people := make([]*PersonFull, 0, len(groups))
+	slugs := make(map[string]string)
+	for _, group := range groups {
+		for _, name := range group.names {
+			slugs[name] = group.slug
+		}
+		people = append(people, &PersonFull{
+			Person: &Person{
+				PersonHead: &PersonHead{
+					Slug: group.slug,
+					Name: group.name,
+				},
+				Usage: group.usage,
+			},
+			LinkedDives: group.dives,
+		})
+	}
+	return people, slugs
+}
+
+type diveGroup struct {
+	slug  string
+	name  string
+	names []string // all spellings, which share the slug
+	usage *Usage
+	dives []*DiveHead
+}
+
+// groupDives groups dives by the names returned by namesOf. Names with the same
+// slug form one group, which takes the name from the most recent dive.
+// Groups are sorted by slug, and dives in a group are in reverse chronological order.
+//
+// A name without letters or digits has an empty slug, so it forms a group of its own,
+// whose slug is a hash of the name. Should that collide with another slug, it gets a numeric suffix,
+// as does a slug which is reserved, e.g. by a route next to the pages of the groups.
+func groupDives(divelog *DiveLog, namesOf func(*Dive) []string, reserved ...string) []*diveGroup {
+	var (
+		groups     []*diveGroup
+		byKey      = make(map[string]*diveGroup)
+		bottomTime = make(map[*diveGroup]time.Duration)
+	)
+	for i := len(divelog.Dives) - 1; i > 0; i-- {
+		dive := divelog.Dives[i]
+		head := NewDiveHead(dive, divelog.DiveSites[dive.DiveSiteID])
+		for _, name := range namesOf(dive) {
+			// a name with an empty slug is keyed by itself, which no slug can equal
+			key := utils.Slug(name)
+			if key == "" {
+				key = "\x00" + name
+			}
+			group, ok := byKey[key]
+			if !ok {
+				group = &diveGroup{
+					slug:  key,
+					name:  name,
+					usage: &Usage{LastDive: head},
+				}
+				byKey[key] = group
+				groups = append(groups, group)
+			}
+			if !slices.Contains(group.names, name) {
+				group.names = append(group.names, name)
+			}
+			group.usage.DiveCount++
+			group.usage.FirstDive = head
+			group.dives = append(group.dives, head)
+			bottomTime[group] += dive.duration
+		}
+	}
+
+	for _, group := range groups {
+		group.usage.BottomTime = utils.FormatHoursMinutes(bottomTime[group])
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].slug < groups[j].slug
+	})
+	// reserved slugs stay in byKey, so that no other group takes them
+	for _, group := range groups {
+		if !slices.Contains(reserved, group.slug) {
+			continue
+		}
+		slug := group.slug
+		for n := 2; byKey[slug] != nil || slices.Contains(reserved, slug); n++ {
+			slug = fmt.Sprintf("%s-%d", group.slug, n)
+		}
+		byKey[slug] = group
+		group.slug = slug
+	}
+	// groups keyed by name sort first, and take hashes after all slugs are known
+	for _, group := range groups {
+		if !strings.HasPrefix(group.slug, "\x00") {
+			continue
+		}
+		slug := utils.ShortHash(group.name)
+		for n := 2; byKey[slug] != nil; n++ {
+			slug = fmt.Sprintf("%s-%d", utils.ShortHash(group.name), n)
+		}
+		byKey[slug] = group
+		group.slug = slug
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].slug < groups[j].slug
+	})
+	return groups
+}
+
+// NewSiteFull lists the dives at the site in reverse chronological order.
+func NewSiteFull(site *DiveSite, divelog *DiveLog) *SiteFull {
+	return &SiteFull{
+		DiveSite:    site,
+		LinkedDives: divelog.index.diveHeads(divelog.index.bySite[site.ID], true),
+	}
+}
+
+// NewTripFull aggregates the dives of a trip in chronological order.
+func NewTripFull(trip *DiveTrip, divelog *DiveLog) *TripFull {
+	t := &TripFull{
+		DiveTrip:    trip,
+		Buddies:     []*PersonHead{},
+		Awards:      []*DiveHead{},
+		LinkedSites: []*TripSite{},
+		LinkedDives: []*DiveHead{},
+	}
+
+	var (
+		first, last time.Time
+		bottomTime  time.Duration
+		depthMax    float64
+		sites       = make(map[int]*TripSite)
+		buddies     = make(map[string]*PersonHead)
+	)
+	for _, dive := range divelog.index.byTrip[trip.ID] {
+		site := divelog.DiveSites[dive.DiveSiteID]
+		head := divelog.index.heads[dive.ID]
+
+		t.DiveCount++
+		t.LinkedDives = append(t.LinkedDives, head)
+		if dive.Award != "" {
+			t.Awards = append(t.Awards, head)
+		}
+		if first.IsZero() || dive.datetime.Before(first) {
+			first = dive.datetime
+		}
+		if dive.datetime.After(last) {
+			last = dive.datetime
+		}
+		bottomTime += dive.duration
+		depthMax = max(depthMax, dive.depthMax)
+
+		if tripSite, ok := sites[site.ID]; ok {
+			tripSite.DiveCount++
+		} else {
+			sites[site.ID] = &TripSite{
+				SiteHead: &SiteHead{
+					ID:       site.ID,
+					StableID: site.StableID,
+					Name:     site.Name,
+				},
+				Coordinates: site.Coordinates,
+				DiveCount:   1,
+			}
+			t.LinkedSites = append(t.LinkedSites, sites[site.ID])
+		}
+
+		for _, buddy := range NewPersonHeads(dive.Buddies(), divelog.index.buddySlugs) {
+			if _, ok := buddies[buddy.Slug]; !ok {
+				buddies[buddy.Slug] = buddy
+				t.Buddies = append(t.Buddies, buddy)
+			}
+		}
+	}
+
+	if t.DiveCount > 0 {
+		t.DateFrom = first.Format(time.DateOnly)
+		t.DateTo = last.Format(time.DateOnly)
+		if t.DateFrom == t.DateTo {
+			t.DateRangePretty = first.Format("January 2 2006")
+		} else {
+			t.DateRangePretty = first.Format("January 2 2006") + " - " + last.Format("January 2 2006")
 		}
 	}
-	sort.Slice(s.LinkedDives, func(i, j int) bool {
-		return s.LinkedDives[i].ID > s.LinkedDives[j].ID
+	t.BottomTime = utils.FormatHoursMinutes(bottomTime)
+	t.DepthMax = fmt.Sprintf("%.1f m", depthMax)
+	sort.Slice(t.Buddies, func(i, j int) bool {
+		return t.Buddies[i].Slug < t.Buddies[j].Slug
 	})
-	return s
+
+	return t
+}
+
+// MapBoundingBox returns the bounding box of all trip sites with known
+// coordinates, in the "min_lon,min_lat,max_lon,max_lat" format used by
+// OpenStreetMap, or an empty string if no site has coordinates.
+func (t *TripFull) MapBoundingBox() string {
+	const padding = 0.05
+	var (
+		minLat, minLon = 90.0, 180.0
+		maxLat, maxLon = -90.0, -180.0
+		found          bool
+	)
+	for _, site := range t.LinkedSites {
+		fields := strings.Fields(site.Coordinates)
+		if len(fields) != 2 {
+			continue
+		}
+		lat, latErr := strconv.ParseFloat(fields[0], 64)
+		lon, lonErr := strconv.ParseFloat(fields[1], 64)
+		if latErr != nil || lonErr != nil {
+			continue
+		}
+		minLat, maxLat = min(minLat, lat), max(maxLat, lat)
+		minLon, maxLon = min(minLon, lon), max(maxLon, lon)
+		found = true
+	}
+	if !found {
+		return ""
+	}
+	return fmt.Sprintf("%f,%f,%f,%f", minLon-padding, minLat-padding, maxLon+padding, maxLat+padding)
 }
 
 func (s *SiteFull) URLLongLat() string {
@@ -88,11 +377,21 @@ type Page struct {
 	Title        string
 	Supertitle   string
 	Trips        []*Trip
+	AllTrips     []*TripFull
+	Trip         *TripFull
 	GroupedSites []*GroupedSites
 	Dives        []*DiveHead
 	Tags         map[string]int
 	Dive         *DiveFull
 	Site         *SiteFull
+	People       *PeopleDirectory
+	Person       *PersonFull
+	BuddyGraph   *BuddyGraph
+	Gear         []*GearGroup
+	GearItem     *GearItemFull
+	GearService  *GearServiceReport
+	Training     *TrainingRecord
+	Changes      *ChangeLog
 	About        bool
 	NotFound     bool
 }
@@ -102,6 +401,12 @@ func (p *Page) check() bool {
 	if p.Trips != nil {
 		c++
 	}
+	if p.AllTrips != nil {
+		c++
+	}
+	if p.Trip != nil {
+		c++
+	}
 	if p.GroupedSites != nil {
 		c++
 	}
@@ -117,6 +422,30 @@ func (p *Page) check() bool {
 	if p.Site != nil {
 		c++
 	}
+	if p.People != nil {
+		c++
+	}
+	if p.Person != nil {
+		c++
+	}
+	if p.BuddyGraph != nil {
+		c++
+	}
+	if p.Gear != nil {
+		c++
+	}
+	if p.GearItem != nil {
+		c++
+	}
+	if p.GearService != nil {
+		c++
+	}
+	if p.Training != nil {
+		c++
+	}
+	if p.Changes != nil {
+		c++
+	}
 	if p.About {
 		c++
 	}
@@ -125,3 +454,108 @@ func (p *Page) check() bool {
 	}
 	return c == 1
 }
+
+// BuildStatus describes the snapshot in use, and the latest builder iteration,
+// which may have failed, or found no newer data files. If newer data files could
+// not be built, the snapshot in use is a fallback to an older data file.
+type BuildStatus struct {
+	Generation       uint64          `json:"generation"`
+	RolledBack       bool            `json:"rolled_back"`
+	Source           string          `json:"source"`
+	SourceHash       string          `json:"source_hash"`
+	ModificationTime string          `json:"modification_time"`
+	BuiltAt          string          `json:"built_at"`
+	BuildDuration    string          `json:"build_duration"`
+	DiveCount        int             `json:"dive_count"`
+	DiveSiteCount    int             `json:"dive_site_count"`
+	DiveTripCount    int             `json:"dive_trip_count"`
+	LastAttempt      string          `json:"last_attempt"`
+	LastError        string          `json:"last_error,omitempty"`
+	Fallback         bool            `json:"fallback"`
+	RejectedFiles    []*RejectedFile `json:"rejected_files,omitempty"`
+}
+
+// SnapshotHistory lists the snapshots from the newest to the oldest.
+type SnapshotHistory struct {
+	RolledBack bool        `json:"rolled_back"`
+	Snapshots  []*Snapshot `json:"snapshots"`
+}
+
+type Snapshot struct {
+	Generation       uint64 `json:"generation"`
+	Served           bool   `json:"served"`
+	Source           string `json:"source"`
+	SourceHash       string `json:"source_hash"`
+	ModificationTime string `json:"modification_time"`
+	BuiltAt          string `json:"built_at"`
+	DiveCount        int    `json:"dive_count"`
+}
+
+// NewSnapshotHistory includes the served snapshot even if it is no longer in the history.
+func NewSnapshotHistory(history []*DiveLog, served *DiveLog, rolledBack bool) *SnapshotHistory {
+	list := &SnapshotHistory{
+		RolledBack: rolledBack,
+		Snapshots:  make([]*Snapshot, 0, len(history)+1),
+	}
+	found := false
+	for _, divelog := range history {
+		found = found || divelog == served
+		list.Snapshots = append(list.Snapshots, NewSnapshot(divelog, divelog == served))
+	}
+	if !found && served != nil {
+		list.Snapshots = append(list.Snapshots, NewSnapshot(served, true))
+	}
+	return list
+}
+
+func NewSnapshot(divelog *DiveLog, served bool) *Snapshot {
+	return &Snapshot{
+		Generation:       divelog.Metadata.generation,
+		Served:           served,
+		Source:           divelog.Metadata.Source,
+		SourceHash:       divelog.Metadata.sourceHash,
+		ModificationTime: divelog.Metadata.ModificationTime,
+		BuiltAt:          divelog.Metadata.builtAt.Format(time.RFC3339),
+		DiveCount:        len(divelog.Dives) - 1,
+	}
+}
+
+type RejectedFile struct {
+	Source           string `json:"source"`
+	SourceHash       string `json:"source_hash,omitempty"`
+	ModificationTime string `json:"modification_time"`
+	Error            string `json:"error"`
+}
+
+func NewBuildStatus(divelog *DiveLog, attempt *buildAttempt) *BuildStatus {
+	_, rolledBack := snapshotHistory()
+	status := &BuildStatus{
+		Generation:       divelog.Metadata.generation,
+		RolledBack:       rolledBack,
+		Source:           divelog.Metadata.Source,
+		SourceHash:       divelog.Metadata.sourceHash,
+		ModificationTime: divelog.Metadata.ModificationTime,
+		BuiltAt:          divelog.Metadata.builtAt.Format(time.RFC3339),
+		BuildDuration:    divelog.Metadata.buildDuration.String(),
+		DiveCount:        len(divelog.Dives) - 1,
+		DiveSiteCount:    len(divelog.DiveSites) - 1,
+		DiveTripCount:    len(divelog.DiveTrips) - 1,
+	}
+	if attempt != nil {
+		status.LastAttempt = attempt.time.Format(time.RFC3339)
+		if attempt.err != nil {
+			status.LastError = attempt.err.Error()
+		}
+		for _, rejected := range attempt.rejected {
+			status.RejectedFiles = append(status.RejectedFiles, &RejectedFile{
+				Source:           rejected.path,
+				SourceHash:       rejected.hash,
+				ModificationTime: rejected.modTime.Format(time.RFC3339),
+				Error:            rejected.err.Error(),
+			})
+			// rejected files are newer than the source file of the snapshot in use
+			status.Fallback = true
+		}
+	}
+	return status
+}
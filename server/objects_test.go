@@ -0,0 +1,55 @@
+package server
+
+import (
+	"testing"
+
+	"src.acicovic.me/divelog/server/utils"
+)
+
+func TestCollectPeopleSlugs(t *testing.T) {
+	collision := utils.ShortHash("?")
+	divelog := &DiveLog{
+		DiveSites: []*DiveSite{nil, {ID: 1, Name: "Vis"}},
+		Dives: []*Dive{nil,
+			{ID: 1, DiveSiteID: 1, Buddy: "Ana, ?, !"},
+			{ID: 2, DiveSiteID: 1, Buddy: "ana, " + collision},
+		},
+	}
+	people, slugs := CollectPeople(divelog, (*Dive).Buddies)
+
+	want := map[string]string{
+		"Ana":     "ana",
+		"ana":     "ana",
+		"?":       collision + "-2",
+		"!":       utils.ShortHash("!"),
+		collision: collision,
+	}
+	for name, slug := range want {
+		if slugs[name] != slug {
+			t.Errorf("slug of %q = %q, want %q", name, slugs[name], slug)
+		}
+	}
+	if len(people) != 4 {
+		t.Errorf("%d people, want 4", len(people))
+	}
+	seen := make(map[string]bool)
+	for _, person := range people {
+		if person.Slug == "" || seen[person.Slug] {
+			t.Errorf("person %q has slug %q, want a unique non-empty slug", person.Name, person.Slug)
+		}
+		seen[person.Slug] = true
+	}
+}
+
+func TestCollectPeopleReservedSlugs(t *testing.T) {
+	divelog := &DiveLog{
+		DiveSites: []*DiveSite{nil, {ID: 1, Name: "Vis"}},
+		Dives: []*Dive{nil,
+			{ID: 1, DiveSiteID: 1, Buddy: "Graph, Graph 2"},
+		},
+	}
+	_, slugs := CollectPeople(divelog, (*Dive).Buddies, _reserved_buddy_slugs...)
+	if slugs["Graph"] != "graph-3" || slugs["Graph 2"] != "graph-2" {
+		t.Errorf("slugs = %v, want Graph graph-3 and Graph 2 graph-2", slugs)
+	}
+}
@@ -0,0 +1,259 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A filter is a list of terms separated by whitespace, all of which must match a dive.
+// A term is either a word, matched against the site name, tags and notes, or a field
+// comparison in the format {field}{operator}{value}, e.g. tag:wreck or depth>30.
+// Values containing whitespace can be quoted: site:"Blue Hole". A term prefixed
+// with "-" matches dives not matched by the rest of the term.
+//
+// Text fields (site, region, trip, buddy, operator, suit, tag) support only the ":" operator,
+// and are matched case-insensitively; tag must match a whole tag, the others match substrings.
+// Numeric fields (number, year, depth in meters, duration in minutes, rating) support
+// ":", "=", "<", "<=", ">" and ">=".
+
+type DiveFilter []filterTerm
+
+type filterTerm struct {
+	negated bool
+	match   func(dive *Dive, divelog *DiveLog) bool
+}
+
+var _text_fields = map[string]func(dive *Dive, divelog *DiveLog) []string{
+	"site":     func(d *Dive, dl *DiveLog) []string { return []string{dl.DiveSites[d.DiveSiteID].Name} },
+	"region":   func(d *Dive, dl *DiveLog) []string { return []string{dl.DiveSites[d.DiveSiteID].Region} },
+	"trip":     func(d *Dive, dl *DiveLog) []string { return []string{dl.DiveTrips[d.DiveTripID].Label} },
+	"buddy":    func(d *Dive, _ *DiveLog) []string { return d.Buddies() },
+	"operator": func(d *Dive, _ *DiveLog) []string { return d.Operators() },
+	"suit":     func(d *Dive, _ *DiveLog) []string { return []string{d.Suit} },
+	"tag":      func(d *Dive, _ *DiveLog) []string { return d.Tags },
+}
+
+var _numeric_fields = map[string]func(dive *Dive) float64{
+	"number":   func(d *Dive) float64 { return float64(d.Number) },
+	"year":     func(d *Dive) float64 { return float64(d.datetime.Year()) },
+	"depth":    func(d *Dive) float64 { return d.depthMax },
+	"duration": func(d *Dive) float64 { return d.duration.Minutes() },
+	"rating":   func(d *Dive) float64 { return float64(d.Rating5) },
+}
+
+// Longer operators first, so that e.g. ">=" is not parsed as ">".
+var _filter_operators = []string{">=", "<=", ":", "=", ">", "<"}
+
+// ParseDiveFilter parses a filter, reporting all invalid terms together.
+func ParseDiveFilter(filter string) (DiveFilter, error) {
+	words, err := splitFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		terms    DiveFilter
+		problems []error
+	)
+	for _, word := range words {
+		term, err := parseFilterTerm(word)
+		if err != nil {
+			problems = append(problems, err)
+			continue
+		}
+		terms = append(terms, term)
+	}
+	if len(problems) > 0 {
+		return nil, errors.Join(problems...)
+	}
+	return terms, nil
+}
+
+// splitFilter splits the filter on whitespace outside of double quotes, and removes the quotes.
+func splitFilter(filter string) ([]string, error) {
+	var (
+		words   []string
+		word    strings.Builder
+		quoted  bool
+		pending bool
+	)
+	for _, r := range filter {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			pending = true
+		case !quoted && (r == ' ' || r == '\t' || r == '\n'):
+			if pending {
+				words = append(words, word.String())
+				word.Reset()
+				pending = false
+			}
+		default:
+			word.WriteRune(r)
+			pending = true
+		}
+	}
+	if quoted {
+		return nil, errors.New("unterminated quote in filter")
+	}
+	if pending {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+func parseFilterTerm(word string) (filterTerm, error) {
+	term := filterTerm{}
+	if rest, ok := strings.CutPrefix(word, "-"); ok && rest != "" {
+		term.negated, word = true, rest
+	}
+
+	field, operator, value := "", "", ""
+	for _, op := range _filter_operators {
+		if i := strings.Index(word, op); i > 0 {
+			// the operator closest to the start of the word separates the field
+			if operator == "" || i < len(field) {
+				field, operator, value = word[:i], op, word[i+len(op):]
+			}
+		}
+	}
+
+	if operator == "" {
+		needle := strings.ToLower(word)
+		term.match = func(d *Dive, dl *DiveLog) bool {
+			haystack := []string{dl.DiveSites[d.DiveSiteID].Name, d.Notes}
+			haystack = append(haystack, d.Tags...)
+			return containsFold(haystack, needle)
+		}
+		return term, nil
+	}
+
+	if valuesOf, ok := _text_fields[field]; ok {
+		if operator != ":" {
+			return term, fmt.Errorf("%q: field %q supports only the \":\" operator", word, field)
+		}
+		needle := strings.ToLower(value)
+		if field == "tag" {
+			term.match = func(d *Dive, dl *DiveLog) bool {
+				for _, tag := range valuesOf(d, dl) {
+					if strings.EqualFold(tag, needle) {
+						return true
+					}
+				}
+				return false
+			}
+		} else {
+			term.match = func(d *Dive, dl *DiveLog) bool {
+				return containsFold(valuesOf(d, dl), needle)
+			}
+		}
+		return term, nil
+	}
+
+	if valueOf, ok := _numeric_fields[field]; ok {
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return term, fmt.Errorf("%q: value %q of field %q is not a number", word, value, field)
+		}
+		term.match = func(d *Dive, _ *DiveLog) bool {
+			return compareNumbers(valueOf(d), operator, number)
+		}
+		return term, nil
+	}
+
+	return term, fmt.Errorf("%q: unknown field %q", word, field)
+}
+
+func containsFold(haystack []string, lowerNeedle string) bool {
+	for _, s := range haystack {
+		if strings.Contains(strings.ToLower(s), lowerNeedle) {
+			return true
+		}
+	}
+	return false
+}
+
+func compareNumbers(a float64, operator string, b float64) bool {
+	switch operator {
+	case ">=":
+		return a >= b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case "<":
+		return a < b
+	default:
+		return a == b
+	}
+}
+
+func (f DiveFilter) Match(dive *Dive, divelog *DiveLog) bool {
+	for _, term := range f {
+		if term.match(dive, divelog) == term.negated {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply returns the matching dives in the order of the dive log.
+func (f DiveFilter) Apply(divelog *DiveLog) []*Dive {
+	var dives []*Dive
+	for _, dive := range divelog.Dives[1:] {
+		if f.Match(dive, divelog) {
+			dives = append(dives, dive)
+		}
+	}
+	return dives
+}
+
+func query(args []string) int {
+	fs := newCommandFlags("query")
+	asJSON := fs.Bool("json", false, "print matching dives in JSON format")
+	if !parseCommandFlags(fs, args, 2) {
+		return exitUsage
+	}
+
+	filter, err := ParseDiveFilter(fs.Arg(0))
+	if err != nil {
+		return fail(exitUsage, "invalid filter: %v", err)
+	}
+
+	divelog, err := loadDiveLog(fs.Arg(1))
+	if err != nil {
+		return failLoading(err)
+	}
+
+	dives := filter.Apply(divelog)
+	if *asJSON {
+		if dives == nil {
+			dives = []*Dive{}
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(dives); err != nil {
+			return fail(exitFailure, "%v", err)
+		}
+		return exitOK
+	}
+
+	for _, dive := range dives {
+		site := divelog.DiveSites[dive.DiveSiteID]
+		fmt.Printf("%5d  %s  %-8s %-10s %s  %s\n",
+			dive.Number,
+			dive.datetime.Format(time.DateOnly),
+			dive.DepthMax,
+			dive.Duration,
+			dive.StableID,
+			site.Name,
+		)
+	}
+	fmt.Fprintf(os.Stderr, "%d of %d dives matched\n", len(dives), len(divelog.Dives)-1)
+	return exitOK
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// queryTestLog returns a dive log with two sites, one trip and three dives.
+func queryTestLog() *DiveLog {
+	return &DiveLog{
+		DiveSites: []*DiveSite{nil,
+			{ID: 1, Name: "Blue Hole", Region: "Red Sea"},
+			{ID: 2, Name: "Vis", Region: "Mediterranean Sea"},
+		},
+		DiveTrips: []*DiveTrip{nil, {ID: 1, Label: "Dahab 2023"}},
+		Dives: []*Dive{nil,
+			{ID: 1, Number: 1, DiveSiteID: 1, DiveTripID: 1, Tags: []string{"reef"}, Buddy: "Ana", Rating5: 5,
+				datetime: time.Date(2023, time.May, 1, 10, 0, 0, 0, time.UTC), depthMax: 30, duration: 45 * time.Minute},
+			{ID: 2, Number: 2, DiveSiteID: 1, DiveTripID: 1, Tags: []string{"wreck", "reef-edge"}, Notes: "Strong current",
+				datetime: time.Date(2023, time.May, 2, 10, 0, 0, 0, time.UTC), depthMax: 18.5, duration: 60 * time.Minute},
+			{ID: 3, Number: 3, DiveSiteID: 2, DiveTripID: 1, Tags: []string{"Wreck"}, Buddy: "Marko, Ana",
+				datetime: time.Date(2024, time.June, 1, 10, 0, 0, 0, time.UTC), depthMax: 40, duration: 35 * time.Minute},
+		},
+	}
+}
+
+func TestParseDiveFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   []int // numbers of the matching dives
+	}{
+		{"", []int{1, 2, 3}},
+		{"reef", []int{1, 2}},
+		{"CURRENT", []int{2}},
+		{"tag:wreck", []int{2, 3}},
+		{"tag:reef", []int{1}},
+		{"site:blue", []int{1, 2}},
+		{`site:"Blue Hole"`, []int{1, 2}},
+		{`"blue hole"`, []int{1, 2}},
+		{"region:red", []int{1, 2}},
+		{"trip:dahab", []int{1, 2, 3}},
+		{"buddy:ana", []int{1, 3}},
+		{"depth>30", []int{3}},
+		{"depth>=30", []int{1, 3}},
+		{"depth<20", []int{2}},
+		{"depth<=18.5", []int{2}},
+		{"duration=45", []int{1}},
+		{"number:2", []int{2}},
+		{"year:2023 rating>4", []int{1}},
+		{"-tag:wreck", []int{1}},
+		{"tag:wreck -site:vis", []int{2}},
+		{"-", []int{2}}, // a word, not a negation
+		{"site:vis:x", nil},
+	}
+	divelog := queryTestLog()
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			filter, err := ParseDiveFilter(tt.filter)
+			if err != nil {
+				t.Fatalf("ParseDiveFilter(%q): %v", tt.filter, err)
+			}
+			var got []int
+			for _, dive := range filter.Apply(divelog) {
+				got = append(got, dive.Number)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("dives matching %q = %v, want %v", tt.filter, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDiveFilterProblems(t *testing.T) {
+	tests := []struct {
+		filter   string
+		problems []string
+	}{
+		{`site:"Blue Hole`, []string{"unterminated quote"}},
+		{"color:blue", []string{`unknown field "color"`}},
+		{"tag>wreck", []string{`supports only the ":" operator`}},
+		{"depth>deep", []string{`value "deep" of field "depth" is not a number`}},
+		{"color:blue depth>deep reef", []string{`unknown field "color"`, `is not a number`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			_, err := ParseDiveFilter(tt.filter)
+			if err == nil {
+				t.Fatalf("ParseDiveFilter(%q) = nil error, want %q", tt.filter, tt.problems)
+			}
+			for _, problem := range tt.problems {
+				if !strings.Contains(err.Error(), problem) {
+					t.Errorf("error %q does not report %q", err, problem)
+				}
+			}
+		})
+	}
+}
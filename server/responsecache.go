@@ -0,0 +1,185 @@
+package server
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Responses of page and data handlers only depend on the served snapshot and the request URL,
+// so they are cached until another snapshot is served. Some pages show dates relative to today
+// (e.g. how long ago a dive was), so cached responses also expire at midnight UTC.
+// The least recently used responses are evicted once the cache is full.
+
+// Larger responses are not cached, so that a few of them cannot evict all others.
+const maxCachedResponseShare = 8
+
+type cachedResponse struct {
+	key    string
+	day    string
+	status int
+	header http.Header
+	body   []byte
+
+	// compressed bodies by encoding
+	encodedLock sync.Mutex
+	encoded     map[string][]byte
+
+	// accounted while cached, guarded by the response cache
+	size int64
+}
+
+var _response_cache = struct {
+	sync.Mutex
+	generation uint64
+	entries    map[string]*list.Element
+	recent     *list.List // of *cachedResponse, the most recently used first
+	size       int64
+	hits       uint64
+	misses     uint64
+}{
+	entries: make(map[string]*list.Element),
+	recent:  list.New(),
+}
+
+// resetResponseCache drops all cached responses, once the snapshot of the given generation is served.
+func resetResponseCache(generation uint64) {
+	c := &_response_cache
+	c.Lock()
+	defer c.Unlock()
+	if c.hits+c.misses > 0 {
+		trace(_https, "response cache of snapshot %d dropped: %d responses, %d bytes, %d hits, %d misses",
+			c.generation, len(c.entries), c.size, c.hits, c.misses)
+	}
+	c.generation = generation
+	c.entries = make(map[string]*list.Element)
+	c.recent.Init()
+	c.size = 0
+	c.hits, c.misses = 0, 0
+}
+
+// responseCacheKey normalizes the request URL, so that the order of query parameters does not matter.
+func responseCacheKey(r *http.Request) string {
+	if r.URL.RawQuery == "" {
+		return r.URL.Path
+	}
+	return r.URL.Path + "?" + r.URL.Query().Encode()
+}
+
+func lookupResponse(divelog *DiveLog, key string, day string) *cachedResponse {
+	c := &_response_cache
+	c.Lock()
+	defer c.Unlock()
+	if divelog.Metadata.generation != c.generation {
+		return nil
+	}
+	element, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		_response_cache_misses.Add(1)
+		return nil
+	}
+	response := element.Value.(*cachedResponse)
+	if response.day != day {
+		evictResponse(element)
+		c.misses++
+		_response_cache_misses.Add(1)
+		return nil
+	}
+	c.recent.MoveToFront(element)
+	c.hits++
+	_response_cache_hits.Add(1)
+	return response
+}
+
+// storeResponse caches the response, unless another snapshot is served by now.
+func storeResponse(divelog *DiveLog, response *cachedResponse) {
+	limit := _control_block.responseCacheSize
+	response.size = int64(len(response.key) + len(response.body))
+	if response.size > limit/maxCachedResponseShare {
+		return
+	}
+
+	c := &_response_cache
+	c.Lock()
+	defer c.Unlock()
+	if divelog.Metadata.generation != c.generation {
+		return
+	}
+	if element, ok := c.entries[response.key]; ok {
+		evictResponse(element)
+	}
+	c.entries[response.key] = c.recent.PushFront(response)
+	c.size += response.size
+	for c.size > limit {
+		evictResponse(c.recent.Back())
+	}
+}
+
+// growResponse accounts for a compressed body added to the response, if it is cached.
+func growResponse(response *cachedResponse, n int64) {
+	c := &_response_cache
+	c.Lock()
+	defer c.Unlock()
+	if element, ok := c.entries[response.key]; !ok || element.Value != response {
+		return
+	}
+	response.size += n
+	c.size += n
+	for c.size > _control_block.responseCacheSize {
+		evictResponse(c.recent.Back())
+	}
+}
+
+// responseCacheSize returns the size of the cached responses.
+func responseCacheSize() int64 {
+	c := &_response_cache
+	c.Lock()
+	defer c.Unlock()
+	return c.size
+}
+
+// evictResponse must be called with the response cache locked.
+func evictResponse(element *list.Element) {
+	c := &_response_cache
+	response := c.recent.Remove(element).(*cachedResponse)
+	delete(c.entries, response.key)
+	c.size -= response.size
+}
+
+// funcWithCachedResponse is funcWithDataAccess for handlers whose successful responses
+// only depend on the snapshot and the request URL.
+func funcWithCachedResponse(fn func(http.ResponseWriter, *http.Request, *DiveLog)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		divelog := acquireDataAccess()
+		if divelog == nil {
+			fn(w, r, divelog)
+			return
+		}
+
+		caching := _control_block.responseCacheSize > 0
+		key := responseCacheKey(r)
+		day := time.Now().UTC().Format(time.DateOnly)
+		if caching {
+			if response := lookupResponse(divelog, key, day); response != nil {
+				response.serve(w, r)
+				return
+			}
+		}
+
+		response := recordResponse(r, func(w http.ResponseWriter, r *http.Request) {
+			fn(w, r, divelog)
+		})
+		response.key, response.day = key, day
+		lastModified := divelog.Metadata.builtAt
+		if rolledBack() {
+			lastModified = time.Time{}
+		}
+		response.setValidators(divelog.Metadata.generation, lastModified, CacheControlSnapshot)
+		if caching && response.status == http.StatusOK {
+			storeResponse(divelog, response)
+		}
+		response.serve(w, r)
+	}
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// The latest snapshots built by the builder are kept, from the newest to the oldest, so that
+// the served snapshot can be rolled back, e.g. after dives were deleted by accident in Subsurface.
+// A rollback lasts until a data file newer than the newest snapshot at the time of the rollback
+// is built; rebuilds of the same data file (e.g. with changed mappings) do not end it.
+// Rollbacks are kept across restarts in the cache directory, by the source file and content
+// hash of the snapshot, and end if that data file is gone or changed by the next boot.
+
+const rollbackFileName = "rollback.json"
+
+var errSnapshotNotFound = errors.New("snapshot not found")
+
+type rollback struct {
+	divelog *DiveLog
+	until   time.Time
+}
+
+// rollbackState is a rollback as stored in the cache directory.
+type rollbackState struct {
+	Source     string    `json:"source"`
+	SourceHash string    `json:"source_hash"`
+	Until      time.Time `json:"until"`
+}
+
+var _snapshots = struct {
+	sync.Mutex
+	history    []*DiveLog
+	generation uint64
+	rollback   *rollback
+	// a rollback read on boot, until the snapshot of its data file is published
+	pending *rollbackState
+	// the last snapshot built by the previous run, until the first snapshot is published
+	baseline *DiveLog
+}{}
+
+// publishSnapshot adds the snapshot to the history, and serves it unless the served snapshot is rolled back.
+func publishSnapshot(divelog *DiveLog) {
+	s := &_snapshots
+	s.Lock()
+	defer s.Unlock()
+
+	s.generation++
+	divelog.Metadata.generation = s.generation
+	if len(s.history) > 0 {
+		recordChanges(s.history[0], divelog)
+	} else if s.baseline != nil {
+		recordChanges(s.baseline, divelog)
+	}
+	s.baseline = nil
+	s.history = append([]*DiveLog{divelog}, s.history...)
+	if len(s.history) > _control_block.snapshotHistory {
+		s.history = s.history[:_control_block.snapshotHistory]
+	}
+
+	if p := s.pending; p != nil {
+		switch {
+		case divelog.Metadata.Source == p.Source && divelog.Metadata.sourceHash == p.SourceHash:
+			trace(_build, "rollback to snapshot %d of %s restored", divelog.Metadata.generation, p.Source)
+			s.rollback, s.pending = &rollback{divelog: divelog, until: p.Until}, nil
+			swapLatestData(divelog)
+			return
+		case divelog.Metadata.modTime.After(p.Until):
+			trace(_build, "rollback to %s ended by a newer data file", p.Source)
+			s.pending = nil
+			removeRollbackState()
+		}
+	}
+
+	if rb := s.rollback; rb != nil {
+		if !divelog.Metadata.modTime.After(rb.until) {
+			trace(_build, "snapshot %d not served, rolled back to snapshot %d", divelog.Metadata.generation, rb.divelog.Metadata.generation)
+			return
+		}
+		trace(_build, "rollback to snapshot %d ended by a newer data file", rb.divelog.Metadata.generation)
+		s.rollback = nil
+		removeRollbackState()
+	}
+	swapLatestData(divelog)
+}
+
+// newestSnapshot returns the newest snapshot built, which is not the served snapshot after a rollback.
+func newestSnapshot() *DiveLog {
+	s := &_snapshots
+	s.Lock()
+	defer s.Unlock()
+	if len(s.history) == 0 {
+		return nil
+	}
+	return s.history[0]
+}
+
+// rollbackSnapshot serves the snapshot from the history; rolling back to the newest snapshot ends a rollback.
+func rollbackSnapshot(generation uint64) (*DiveLog, error) {
+	s := &_snapshots
+	s.Lock()
+	defer s.Unlock()
+
+	for i, divelog := range s.history {
+		if divelog.Metadata.generation != generation {
+			continue
+		}
+		if i == 0 {
+			s.rollback = nil
+			removeRollbackState()
+		} else {
+			s.rollback = &rollback{divelog: divelog, until: s.history[0].Metadata.modTime}
+			storeRollbackState(&rollbackState{
+				Source:     divelog.Metadata.Source,
+				SourceHash: divelog.Metadata.sourceHash,
+				Until:      s.history[0].Metadata.modTime,
+			})
+		}
+		s.pending = nil
+		swapLatestData(divelog)
+		trace(_control, "rolled back to snapshot %d built from %s", generation, divelog.Metadata.Source)
+		return divelog, nil
+	}
+	return nil, errSnapshotNotFound
+}
+
+// rolledBack reports whether the served snapshot is rolled back.
+func rolledBack() bool {
+	s := &_snapshots
+	s.Lock()
+	defer s.Unlock()
+	return s.rollback != nil
+}
+
+// snapshotHistory returns the snapshots from the newest to the oldest, and whether the served snapshot is rolled back.
+func snapshotHistory() ([]*DiveLog, bool) {
+	s := &_snapshots
+	s.Lock()
+	defer s.Unlock()
+	return append([]*DiveLog(nil), s.history...), s.rollback != nil
+}
+
+// loadRollbackState reads the rollback kept in the cache directory on boot, if any. It is restored
+// once the snapshot of its data file is published, see loadCachedSnapshot and buildFromLatestDataFile.
+func loadRollbackState() *rollbackState {
+	if _control_block.cacheDir == "" {
+		return nil
+	}
+	data, err := os.ReadFile(rollbackStatePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	state := &rollbackState{}
+	if err == nil {
+		err = json.Unmarshal(data, state)
+	}
+	if err != nil {
+		trace(_error, "rollback not restored: %v", err)
+		removeRollbackState()
+		return nil
+	}
+
+	s := &_snapshots
+	s.Lock()
+	defer s.Unlock()
+	s.pending = state
+	trace(_build, "rollback to %s kept from the previous run", state.Source)
+	return state
+}
+
+// pendingRollback returns the rollback read on boot, unless it was restored or ended since.
+func pendingRollback() *rollbackState {
+	s := &_snapshots
+	s.Lock()
+	defer s.Unlock()
+	return s.pending
+}
+
+// abandonPendingRollback ends the rollback read on boot, whose snapshot cannot be published.
+func abandonPendingRollback(reason string) {
+	s := &_snapshots
+	s.Lock()
+	defer s.Unlock()
+	if s.pending == nil {
+		return
+	}
+	trace(_error, "rollback to %s ended, %s", s.pending.Source, reason)
+	s.pending = nil
+	removeRollbackState()
+}
+
+func rollbackStatePath() string {
+	return filepath.Join(_control_block.cacheDir, rollbackFileName)
+}
+
+// storeRollbackState must be called with the snapshots locked.
+// Failures are only traced, the rollback is then not kept across a restart.
+func storeRollbackState(state *rollbackState) {
+	if _control_block.cacheDir == "" {
+		return
+	}
+	data, err := json.Marshal(state)
+	if err == nil {
+		err = os.MkdirAll(_control_block.cacheDir, 0o755)
+	}
+	if err == nil {
+		err = writeFileAtomically(rollbackStatePath(), data)
+	}
+	if err != nil {
+		trace(_error, "failed to keep the rollback for the next run: %v", err)
+	}
+}
+
+// removeRollbackState must be called with the snapshots locked.
+func removeRollbackState() {
+	if _control_block.cacheDir == "" {
+		return
+	}
+	if err := os.Remove(rollbackStatePath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		trace(_error, "failed to remove the kept rollback: %v", err)
+	}
+}
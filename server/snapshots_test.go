@@ -0,0 +1,91 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+// snapshotTestLog returns an empty snapshot of the data file.
+func snapshotTestLog(source string, hash string, modTime time.Time) *DiveLog {
+	divelog := &DiveLog{
+		DiveSites:     []*DiveSite{nil},
+		DiveTrips:     []*DiveTrip{nil},
+		Dives:         []*Dive{nil},
+		stableSiteIDs: make(map[string]int),
+		stableTripIDs: make(map[string]int),
+		stableDiveIDs: make(map[string]int),
+	}
+	divelog.Metadata.Source, divelog.Metadata.sourceHash, divelog.Metadata.modTime = source, hash, modTime
+	return divelog
+}
+
+// resetSnapshots isolates the test from the snapshot history and the served snapshot.
+func resetSnapshots(t *testing.T) {
+	t.Helper()
+	history, cacheDir := _control_block.snapshotHistory, _control_block.cacheDir
+	t.Cleanup(func() {
+		_snapshots.history, _snapshots.generation, _snapshots.rollback, _snapshots.pending = nil, 0, nil, nil
+		_divelog_latest.Store(nil)
+		_control_block.snapshotHistory, _control_block.cacheDir = history, cacheDir
+	})
+	_snapshots.history, _snapshots.generation, _snapshots.rollback, _snapshots.pending = nil, 0, nil, nil
+	_control_block.snapshotHistory = 5
+	_control_block.cacheDir = t.TempDir()
+}
+
+func TestRollbackKeptAcrossRestart(t *testing.T) {
+	resetSnapshots(t)
+	day := time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC)
+
+	publishSnapshot(snapshotTestLog("a.xml", "a", day))
+	publishSnapshot(snapshotTestLog("b.xml", "b", day.Add(time.Hour)))
+	if _, err := rollbackSnapshot(1); err != nil {
+		t.Fatal(err)
+	}
+
+	// restart
+	_snapshots.history, _snapshots.rollback = nil, nil
+	_divelog_latest.Store(nil)
+	if state := loadRollbackState(); state == nil || state.Source != "a.xml" || !state.Until.Equal(day.Add(time.Hour)) {
+		t.Fatalf("rollback state = %+v, want a rollback to a.xml until b.xml", state)
+	}
+
+	// the changed content of a.xml is served, until the rolled back content is published
+	publishSnapshot(snapshotTestLog("a.xml", "changed", day))
+	if got := acquireDataAccess().Metadata.sourceHash; got != "changed" {
+		t.Errorf("served %q, want the only snapshot", got)
+	}
+	target := snapshotTestLog("a.xml", "a", day)
+	publishSnapshot(target)
+	if _, rolledBack := snapshotHistory(); !rolledBack || acquireDataAccess() != target {
+		t.Errorf("rolled back %t, want the rollback restored", rolledBack)
+	}
+
+	publishSnapshot(snapshotTestLog("b.xml", "b", day.Add(time.Hour)))
+	if acquireDataAccess() != target {
+		t.Errorf("served %q, want the rollback to last", acquireDataAccess().Metadata.Source)
+	}
+	publishSnapshot(snapshotTestLog("c.xml", "c", day.Add(2*time.Hour)))
+	if _, rolledBack := snapshotHistory(); rolledBack || acquireDataAccess().Metadata.Source != "c.xml" {
+		t.Errorf("rolled back %t, want the rollback ended by a newer data file", rolledBack)
+	}
+	if _, err := os.Stat(rollbackStatePath()); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("rollback state file: %v, want it removed", err)
+	}
+}
+
+func TestPendingRollbackAbandoned(t *testing.T) {
+	resetSnapshots(t)
+	storeRollbackState(&rollbackState{Source: "a.xml", SourceHash: "a", Until: time.Now()})
+	loadRollbackState()
+
+	restorePendingRollback(pendingRollback(), []*dataFile{{path: "b.xml"}})
+	if pendingRollback() != nil {
+		t.Error("rollback pending, want it ended as its data file is gone")
+	}
+	if loadRollbackState() != nil {
+		t.Error("rollback state kept, want it removed")
+	}
+}
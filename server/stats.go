@@ -0,0 +1,171 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"src.acicovic.me/divelog/server/utils"
+)
+
+type Stats struct {
+	Dives       int            `json:"dives"`
+	LoggedDives int            `json:"logged_dives"`
+	DiveSites   int            `json:"dive_sites"`
+	DiveTrips   int            `json:"dive_trips"`
+	Buddies     int            `json:"buddies"`
+	Operators   int            `json:"operators"`
+	BottomTime  string         `json:"bottom_time"`
+	FirstDive   string         `json:"first_dive,omitempty"`
+	LastDive    string         `json:"last_dive,omitempty"`
+	DeepestDive *DiveRecord    `json:"deepest_dive,omitempty"`
+	LongestDive *DiveRecord    `json:"longest_dive,omitempty"`
+	Years       []*YearStats   `json:"years"`
+	Regions     []*RegionStats `json:"regions"`
+}
+
+type DiveRecord struct {
+	*DiveHead
+	Value string `json:"value"`
+}
+
+type RegionStats struct {
+	Region string `json:"region"`
+	Dives  int    `json:"dives"`
+}
+
+type YearStats struct {
+	Year       int    `json:"year"`
+	Dives      int    `json:"dives"`
+	BottomTime string `json:"bottom_time"`
+}
+
+// NewStats summarizes the dive log. Regions are sorted by the number of dives.
+func NewStats(divelog *DiveLog) *Stats {
+	stats := &Stats{
+		Dives:       len(divelog.Dives) - 1,
+		LoggedDives: divelog.LoggedDives(),
+		DiveSites:   len(divelog.DiveSites) - 1,
+		DiveTrips:   len(divelog.DiveTrips) - 1,
+		Buddies:     len(divelog.index.buddies),
+		Operators:   len(divelog.index.operators),
+		Years:       []*YearStats{},
+		Regions:     []*RegionStats{},
+	}
+
+	var (
+		bottomTime  time.Duration
+		first, last *Dive
+		deepest     *Dive
+		longest     *Dive
+		regionDives = make(map[string]int)
+	)
+	for _, dive := range divelog.Dives[1:] {
+		bottomTime += dive.duration
+		if first == nil || dive.datetime.Before(first.datetime) {
+			first = dive
+		}
+		if last == nil || dive.datetime.After(last.datetime) {
+			last = dive
+		}
+		if deepest == nil || dive.depthMax > deepest.depthMax {
+			deepest = dive
+		}
+		if longest == nil || dive.duration > longest.duration {
+			longest = dive
+		}
+		regionDives[divelog.DiveSites[dive.DiveSiteID].Region]++
+	}
+
+	stats.BottomTime = utils.FormatHoursMinutes(bottomTime)
+	if first != nil {
+		stats.FirstDive = first.datetime.Format(time.DateOnly)
+		stats.LastDive = last.datetime.Format(time.DateOnly)
+		stats.DeepestDive = &DiveRecord{
+			DiveHead: NewDiveHead(deepest, divelog.DiveSites[deepest.DiveSiteID]),
+			Value:    deepest.DepthMax,
+		}
+		stats.LongestDive = &DiveRecord{
+			DiveHead: NewDiveHead(longest, divelog.DiveSites[longest.DiveSiteID]),
+			Value:    longest.Duration,
+		}
+	}
+
+	for _, year := range divelog.index.years {
+		var yearBottomTime time.Duration
+		for _, dive := range divelog.index.byYear[year] {
+			yearBottomTime += dive.duration
+		}
+		stats.Years = append(stats.Years, &YearStats{
+			Year:       year,
+			Dives:      len(divelog.index.byYear[year]),
+			BottomTime: utils.FormatHoursMinutes(yearBottomTime),
+		})
+	}
+
+	for region, dives := range regionDives {
+		stats.Regions = append(stats.Regions, &RegionStats{Region: region, Dives: dives})
+	}
+	sort.Slice(stats.Regions, func(i, j int) bool {
+		if stats.Regions[i].Dives != stats.Regions[j].Dives {
+			return stats.Regions[i].Dives > stats.Regions[j].Dives
+		}
+		return stats.Regions[i].Region < stats.Regions[j].Region
+	})
+
+	return stats
+}
+
+func stats(args []string) int {
+	fs := newCommandFlags("stats")
+	asJSON := fs.Bool("json", false, "print statistics in JSON format")
+	if !parseCommandFlags(fs, args, 1) {
+		return exitUsage
+	}
+
+	divelog, err := loadDiveLog(fs.Arg(0))
+	if err != nil {
+		return failLoading(err)
+	}
+
+	summary := NewStats(divelog)
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(summary); err != nil {
+			return fail(exitFailure, "%v", err)
+		}
+		return exitOK
+	}
+	printStats(os.Stdout, summary)
+	return exitOK
+}
+
+func printStats(w io.Writer, stats *Stats) {
+	fmt.Fprintf(w, "Dives:        %d (%d logged)\n", stats.Dives, stats.LoggedDives)
+	fmt.Fprintf(w, "Dive sites:   %d\n", stats.DiveSites)
+	fmt.Fprintf(w, "Dive trips:   %d\n", stats.DiveTrips)
+	fmt.Fprintf(w, "Buddies:      %d\n", stats.Buddies)
+	fmt.Fprintf(w, "Operators:    %d\n", stats.Operators)
+	fmt.Fprintf(w, "Bottom time:  %s\n", stats.BottomTime)
+	if stats.Dives == 0 {
+		return
+	}
+	fmt.Fprintf(w, "First dive:   %s\n", stats.FirstDive)
+	fmt.Fprintf(w, "Last dive:    %s\n", stats.LastDive)
+	fmt.Fprintf(w, "Deepest dive: %s (%s)\n", stats.DeepestDive.Value, stats.DeepestDive.ShortLabel)
+	fmt.Fprintf(w, "Longest dive: %s (%s)\n", stats.LongestDive.Value, stats.LongestDive.ShortLabel)
+
+	fmt.Fprintf(w, "\nBy year:\n")
+	for _, year := range stats.Years {
+		fmt.Fprintf(w, "  %d  %4d dives  %s\n", year.Year, year.Dives, year.BottomTime)
+	}
+
+	fmt.Fprintf(w, "\nBy region:\n")
+	for _, region := range stats.Regions {
+		fmt.Fprintf(w, "  %-20s %4d dives\n", region.Region, region.Dives)
+	}
+}
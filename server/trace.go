@@ -1,11 +1,27 @@
 package server
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
 	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// Traces are log records written with log/slog, as text or JSON, to the standard output
+// or to a file which is rotated by size. Every record names the subsystem which traced it,
+// and subsystems can be turned off, except for errors. The settings can be changed while
+// the server is running with the admin API.
+
 type TracePrefix string
 
 const (
@@ -18,7 +34,423 @@ const (
 	_https   TracePrefix = "https"
 )
 
+const (
+	LogLevelDebug = "debug"
+	LogLevelInfo  = "info"
+	LogLevelError = "error"
+
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+
+	LogSubsystemsAll = "all"
+
+	// in MiB
+	defaultLogMaxSize  = 10
+	defaultLogMaxFiles = 3
+)
+
+var _log_levels = map[string]slog.Level{
+	LogLevelDebug: slog.LevelDebug,
+	LogLevelInfo:  slog.LevelInfo,
+	LogLevelError: slog.LevelError,
+}
+
+// Traces with prefixes not listed here are at the debug level.
+var _trace_levels = map[TracePrefix]slog.Level{
+	_control: slog.LevelInfo,
+	_build:   slog.LevelInfo,
+	_error:   slog.LevelError,
+}
+
+// Subsystems which can be turned off.
+var _log_subsystems = []TracePrefix{_control, _env, _build, _link, _map, _https}
+
+// LogSettings are the logging settings of the configuration, which can also be changed at runtime.
+type LogSettings struct {
+	Level      string `json:"level"`
+	Format     string `json:"format"`
+	Subsystems string `json:"subsystems"` // comma-separated, or all
+	File       string `json:"file"`
+	MaxSize    int    `json:"max_size"`  // in MiB, 0 for no rotation
+	MaxFiles   int    `json:"max_files"` // rotated files kept, at least 1
+}
+
+func (s *LogSettings) validate() (problems []error) {
+	if _, ok := _log_levels[s.Level]; !ok {
+		problems = append(problems, fmt.Errorf("log level %q is invalid, expected %q, %q or %q", s.Level, LogLevelDebug, LogLevelInfo, LogLevelError))
+	}
+	if s.Format != LogFormatText && s.Format != LogFormatJSON {
+		problems = append(problems, fmt.Errorf("log format %q is invalid, expected %q or %q", s.Format, LogFormatText, LogFormatJSON))
+	}
+	if _, err := parseLogSubsystems(s.Subsystems); err != nil {
+		problems = append(problems, err)
+	}
+	if s.MaxSize < 0 {
+		problems = append(problems, fmt.Errorf("log max size %d must not be negative", s.MaxSize))
+	}
+	if s.MaxFiles < 1 {
+		// without a rotated file, rotation would truncate the log
+		problems = append(problems, fmt.Errorf("log max files %d must be at least 1", s.MaxFiles))
+	}
+	return
+}
+
+// parseLogSubsystems returns the enabled subsystems, or nil if all are enabled.
+func parseLogSubsystems(list string) (map[TracePrefix]bool, error) {
+	if strings.TrimSpace(list) == LogSubsystemsAll {
+		return nil, nil
+	}
+	enabled := make(map[TracePrefix]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !slices.Contains(_log_subsystems, TracePrefix(name)) {
+			return nil, fmt.Errorf("log subsystem %q is invalid, expected %q or a list of %s", name, LogSubsystemsAll, joinPrefixes(_log_subsystems))
+		}
+		enabled[TracePrefix(name)] = true
+	}
+	return enabled, nil
+}
+
+func joinPrefixes(prefixes []TracePrefix) string {
+	names := make([]string, len(prefixes))
+	for i, prefix := range prefixes {
+		names[i] = string(prefix)
+	}
+	return strings.Join(names, ", ")
+}
+
+type logState struct {
+	settings   LogSettings
+	level      slog.Level
+	subsystems map[TracePrefix]bool // nil if all are enabled
+	logger     *slog.Logger
+	file       *rotatingFile // nil if written to the console
+
+	// held for reading while records are logged, and for writing when the state is retired,
+	// so that the file is closed once no record is written to it
+	inUse   sync.RWMutex
+	retired bool
+}
+
+func (s *logState) enabled(prefix TracePrefix, level slog.Level) bool {
+	if level < s.level {
+		return false
+	}
+	return prefix == _error || s.subsystems == nil || s.subsystems[prefix]
+}
+
+var (
+	_log_state atomic.Pointer[logState]
+	// serializes changes of the settings
+	_log_lock sync.Mutex
+	// set before the logging is configured, e.g. by commands which keep the standard output clean
+	_log_console io.Writer = os.Stdout
+)
+
+func defaultLogSettings() LogSettings {
+	return LogSettings{
+		Level:      LogLevelInfo,
+		Format:     LogFormatText,
+		Subsystems: LogSubsystemsAll,
+		MaxSize:    defaultLogMaxSize,
+		MaxFiles:   defaultLogMaxFiles,
+	}
+}
+
+// configureLogging replaces the logger with one of the settings, which must be valid.
+func configureLogging(settings LogSettings) error {
+	_log_lock.Lock()
+	defer _log_lock.Unlock()
+
+	previous := _log_state.Load()
+	state := &logState{settings: settings, level: _log_levels[settings.Level]}
+	state.subsystems, _ = parseLogSubsystems(settings.Subsystems)
+
+	var output io.Writer = _log_console
+	if settings.File != "" {
+		maxSize := int64(settings.MaxSize) << 20
+		if previous != nil && previous.file != nil && previous.file.path == settings.File {
+			state.file = previous.file
+			state.file.setLimits(maxSize, settings.MaxFiles)
+		} else {
+			file, err := openRotatingFile(settings.File, maxSize, settings.MaxFiles)
+			if err != nil {
+				return err
+			}
+			state.file = file
+		}
+		output = state.file
+	}
+
+	options := &slog.HandlerOptions{
+		// records are filtered by trace, before they are formatted
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				a.Value = slog.TimeValue(a.Value.Time().UTC())
+			}
+			return a
+		},
+	}
+	var handler slog.Handler
+	if settings.Format == LogFormatJSON {
+		handler = slog.NewJSONHandler(output, options)
+	} else {
+		handler = slog.NewTextHandler(output, options)
+	}
+	state.logger = slog.New(&contextHandler{handler})
+
+	_log_state.Store(state)
+	if previous != nil {
+		// waits for records logged with the previous logger
+		previous.inUse.Lock()
+		previous.retired = true
+		previous.inUse.Unlock()
+		if previous.file != nil && previous.file != state.file {
+			previous.file.Close()
+		}
+	}
+	return nil
+}
+
+// currentLogSettings returns the settings of the logger in use.
+func currentLogSettings() LogSettings {
+	return loadLogState().settings
+}
+
+func loadLogState() *logState {
+	if state := _log_state.Load(); state != nil {
+		return state
+	}
+	// traced before the configuration is read
+	configureLogging(defaultLogSettings())
+	return _log_state.Load()
+}
+
+// acquireLogState returns the logger in use, which is not retired until released with
+// state.inUse.RUnlock.
+func acquireLogState() *logState {
+	for {
+		state := loadLogState()
+		state.inUse.RLock()
+		if !state.retired {
+			return state
+		}
+		// replaced meanwhile
+		state.inUse.RUnlock()
+	}
+}
+
+func traceLevel(prefix TracePrefix) slog.Level {
+	if level, ok := _trace_levels[prefix]; ok {
+		return level
+	}
+	return slog.LevelDebug
+}
+
 func trace(prefix TracePrefix, format string, args ...interface{}) {
-	// external process control should redirect stdout to a log file
-	fmt.Fprintf(os.Stdout, "%s: %s: %s\n", time.Now().UTC().Format(time.RFC3339), prefix, fmt.Sprintf(format, args...))
+	traceContext(context.Background(), prefix, format, args...)
+}
+
+// traceContext traces with the fields of the request which the context belongs to, if any.
+func traceContext(ctx context.Context, prefix TracePrefix, format string, args ...interface{}) {
+	state := acquireLogState()
+	defer state.inUse.RUnlock()
+	level := traceLevel(prefix)
+	if !state.enabled(prefix, level) {
+		return
+	}
+	state.logger.Log(ctx, level, fmt.Sprintf(format, args...), "subsystem", string(prefix))
+}
+
+// traceDebug traces a debug record with attributes as key-value pairs,
+// e.g. for every dive built, which would flood the log at other levels.
+func traceDebug(prefix TracePrefix, msg string, args ...any) {
+	traceAttrs(context.Background(), slog.LevelDebug, prefix, msg, args...)
+}
+
+func traceAttrs(ctx context.Context, level slog.Level, prefix TracePrefix, msg string, args ...any) {
+	state := acquireLogState()
+	defer state.inUse.RUnlock()
+	if !state.enabled(prefix, level) {
+		return
+	}
+	state.logger.Log(ctx, level, msg, append([]any{"subsystem", string(prefix)}, args...)...)
+}
+
+type logFieldsKey struct{}
+
+// withLogFields returns a context whose traces include the fields, as key-value pairs.
+func withLogFields(ctx context.Context, args ...any) context.Context {
+	fields, _ := ctx.Value(logFieldsKey{}).([]any)
+	return context.WithValue(ctx, logFieldsKey{}, append(slices.Clip(fields), args...))
+}
+
+// contextHandler adds the fields of the context to records.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if fields, ok := ctx.Value(logFieldsKey{}).([]any); ok {
+		record.Add(fields...)
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{h.Handler.WithGroup(name)}
+}
+
+const (
+	HeaderRequestID    = "X-Request-ID"
+	maxRequestIDLength = 64
+)
+
+// LogRequests returns an adapter that adds the request ID, method and path to the traces
+// of every request, and traces every request served at the debug level. The request ID
+// is taken from the X-Request-ID header, e.g. set by a reverse proxy, or generated,
+// and sent back in the same header.
+func LogRequests() Adapter {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(HeaderRequestID)
+			if !validRequestID(id) {
+				id = newRequestID()
+			}
+			w.Header().Set(HeaderRequestID, id)
+			ctx := withLogFields(r.Context(), "request_id", id, "method", r.Method, "path", r.URL.Path)
+
+			start := time.Now()
+			sw := &statusWriter{ResponseWriter: w}
+			h.ServeHTTP(sw, r.WithContext(ctx))
+			if sw.status == 0 {
+				sw.status = http.StatusOK
+			}
+			traceAttrs(ctx, slog.LevelDebug, _https, "request served",
+				"status", sw.status, "duration", time.Since(start), "remote", r.RemoteAddr)
+		})
+	}
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// rotatingFile is a log file which is renamed with the suffix .1 once it would grow larger than
+// maxSize, after older files are renamed to the next suffix, and the file with the suffix
+// maxFiles is removed. A maxSize of 0 turns rotation off.
+type rotatingFile struct {
+	sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := f.open(os.O_APPEND); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open(flag int) error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|flag, 0o644)
+	if err != nil {
+		return fmt.Errorf("open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("open log file: %w", err)
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+func (f *rotatingFile) setLimits(maxSize int64, maxFiles int) {
+	f.Lock()
+	defer f.Unlock()
+	f.maxSize, f.maxFiles = maxSize, maxFiles
+}
+
+func (f *rotatingFile) Write(b []byte) (int, error) {
+	f.Lock()
+	defer f.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(b)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			// the record is lost, but the reason should not be
+			fmt.Fprintf(os.Stderr, "log file %s not rotated: %v\n", f.path, err)
+			if f.file == nil {
+				return 0, err
+			}
+		}
+	}
+	n, err := f.file.Write(b)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate must be called with the file locked. If the files cannot be renamed,
+// writing goes on to the same file.
+func (f *rotatingFile) rotate() error {
+	f.file.Close()
+	err := f.shiftFiles()
+	flag := os.O_TRUNC
+	if err != nil {
+		flag = os.O_APPEND
+	}
+	if openErr := f.open(flag); openErr != nil {
+		f.file = nil
+		return openErr
+	}
+	return err
+}
+
+func (f *rotatingFile) shiftFiles() error {
+	for i := f.maxFiles - 1; i >= 1; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return os.Rename(f.path, f.path+".1")
+}
+
+func (f *rotatingFile) Close() error {
+	f.Lock()
+	defer f.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
 }
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLogSettingsMaxFiles(t *testing.T) {
+	for _, tt := range []struct {
+		maxFiles int
+		valid    bool
+	}{
+		{-1, false},
+		{0, false},
+		{1, true},
+		{defaultLogMaxFiles, true},
+	} {
+		settings := defaultLogSettings()
+		settings.MaxFiles = tt.maxFiles
+		if problems := settings.validate(); (len(problems) == 0) != tt.valid {
+			t.Errorf("max files %d: problems %v, want valid %t", tt.maxFiles, problems, tt.valid)
+		}
+	}
+}
+
+// A record logged while the log file is replaced is written to the previous file, which is
+// closed once it is written.
+func TestConfigureLoggingWaitsForWriters(t *testing.T) {
+	previous := currentLogSettings()
+	t.Cleanup(func() { configureLogging(previous) })
+
+	dir := t.TempDir()
+	settings := defaultLogSettings()
+	settings.File = filepath.Join(dir, "a.log")
+	if err := configureLogging(settings); err != nil {
+		t.Fatal(err)
+	}
+
+	state := acquireLogState()
+	done := make(chan error)
+	go func() {
+		settings.File = filepath.Join(dir, "b.log")
+		done <- configureLogging(settings)
+	}()
+	select {
+	case <-done:
+		t.Fatal("logging configured while a record is written")
+	case <-time.After(50 * time.Millisecond):
+	}
+	state.logger.Info("record")
+	state.inUse.RUnlock()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "a.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte("msg=record")) {
+		t.Errorf("record not written to the previous log file: %q", data)
+	}
+	if file := loadLogState().file; file == nil || file.path != settings.File {
+		t.Errorf("log file not replaced")
+	}
+}
@@ -0,0 +1,342 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"src.acicovic.me/divelog/server/utils"
+)
+
+// Certifications are recorded with _cert_{agency}_{level} dive tags on the dive which
+// completed the certification, and training dives with _course_{name} dive tags.
+// Agencies and their certification levels are described by a catalogue.
+
+type TrainingCatalogue struct {
+	Agencies []*Agency `json:"agencies"`
+}
+
+type Agency struct {
+	ID     string                `json:"id"`
+	Name   string                `json:"name"`
+	Levels []*CertificationLevel `json:"levels"`
+}
+
+// CertificationLevel is a certification issued by an agency. Requires is the ID
+// of a prerequisite level of the same agency, and Course is the name used in
+// _course_{name} tags of training dives, which defaults to the level ID.
+type CertificationLevel struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Requires string `json:"requires,omitempty"`
+	MinDives int    `json:"min_dives,omitempty"`
+	Course   string `json:"course,omitempty"`
+}
+
+var _default_training_catalogue = &TrainingCatalogue{
+	Agencies: []*Agency{
+		{
+			ID:   "padi",
+			Name: "PADI",
+			Levels: []*CertificationLevel{
+				{ID: "owd", Name: "Open Water Diver"},
+				{ID: "aowd", Name: "Advanced Open Water Diver", Requires: "owd"},
+				{ID: "rescue", Name: "Rescue Diver", Requires: "aowd"},
+				{ID: "dm", Name: "Divemaster", Requires: "rescue", MinDives: 60},
+				{ID: "nitrox", Name: "Enriched Air Diver", Requires: "owd"},
+				{ID: "deep", Name: "Deep Diver", Requires: "aowd"},
+				{ID: "wreck", Name: "Wreck Diver", Requires: "aowd"},
+				{ID: "dry", Name: "Dry Suit Diver", Requires: "owd"},
+			},
+		},
+		{
+			ID:   "ssi",
+			Name: "SSI",
+			Levels: []*CertificationLevel{
+				{ID: "owd", Name: "Open Water Diver"},
+				{ID: "aowd", Name: "Advanced Open Water Diver", Requires: "owd", MinDives: 24},
+				{ID: "stress-rescue", Name: "Stress & Rescue", Requires: "owd"},
+				{ID: "dive-guide", Name: "Dive Guide", Requires: "stress-rescue", MinDives: 40},
+				{ID: "nitrox", Name: "Enriched Air Nitrox", Requires: "owd"},
+				{ID: "navigation", Name: "Navigation", Requires: "owd"},
+				{ID: "dry", Name: "Dry Suit Diving", Requires: "owd"},
+			},
+		},
+		{
+			ID:   "cmas",
+			Name: "CMAS",
+			Levels: []*CertificationLevel{
+				{ID: "1-star", Name: "One Star Diver"},
+				{ID: "2-star", Name: "Two Star Diver", Requires: "1-star", MinDives: 25},
+				{ID: "3-star", Name: "Three Star Diver", Requires: "2-star", MinDives: 50},
+			},
+		},
+	},
+}
+
+type TrainingRecord struct {
+	LoggedDives    int                  `json:"logged_dives"`
+	Certifications []*Certification     `json:"certifications"`
+	Courses        []*Course            `json:"courses_in_progress"`
+	NextLevels     []*CertificationStep `json:"next_levels"`
+}
+
+type Certification struct {
+	Agency         string      `json:"agency"`
+	Level          string      `json:"level"`
+	Title          string      `json:"title"`
+	Date           string      `json:"date"`
+	CertifyingDive *DiveHead   `json:"certifying_dive"`
+	TrainingDives  []*DiveHead `json:"training_dives"`
+}
+
+type Course struct {
+	Name          string      `json:"name"`
+	TrainingDives []*DiveHead `json:"training_dives"`
+}
+
+// CertificationStep is a certification level not held yet,
+// whose prerequisite level is held.
+type CertificationStep struct {
+	Title         string `json:"title"`
+	Prerequisite  string `json:"prerequisite"`
+	DivesRequired int    `json:"dives_required"`
+	DivesLogged   int    `json:"dives_logged"`
+	Eligible      bool   `json:"eligible"`
+}
+
+type certTag struct {
+	agency string
+	level  string
+}
+
+// Only builder() accesses these, see _divelog.
+var (
+	_training_catalogue         = _default_training_catalogue
+	_training_catalogue_modTime time.Time
+	_training_catalogue_checked bool
+)
+
+// LoadTrainingCatalogue reads and validates a training catalogue.
+// All problems found in the file are reported together.
+func LoadTrainingCatalogue(path string) (*TrainingCatalogue, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	catalogue := &TrainingCatalogue{}
+	if err := json.Unmarshal(data, catalogue); err != nil {
+		return nil, fmt.Errorf("failed to parse training catalogue %s: %v", path, err)
+	}
+
+	var (
+		problems []error
+		agencies = make(map[string]bool)
+	)
+	for i, agency := range catalogue.Agencies {
+		if agency.ID == "" || utils.Slug(agency.ID) != agency.ID || strings.Contains(agency.ID, "_") {
+			problems = append(problems, fmt.Errorf("agency %d: id %q must be non-empty, lowercase and contain only letters, digits and hyphens", i+1, agency.ID))
+		} else if agencies[agency.ID] {
+			problems = append(problems, fmt.Errorf("agency %d: duplicate id %q", i+1, agency.ID))
+		}
+		agencies[agency.ID] = true
+		if agency.Name == "" {
+			agency.Name = strings.ToUpper(agency.ID)
+		}
+
+		levels := make(map[string]bool)
+		for _, level := range agency.Levels {
+			if level.ID == "" || utils.Slug(level.ID) != level.ID {
+				problems = append(problems, fmt.Errorf("agency %q: level id %q must be non-empty, lowercase and contain only letters, digits and hyphens", agency.ID, level.ID))
+			} else if levels[level.ID] {
+				problems = append(problems, fmt.Errorf("agency %q: duplicate level id %q", agency.ID, level.ID))
+			}
+			levels[level.ID] = true
+			if level.Name == "" {
+				level.Name = level.ID
+			}
+			if level.MinDives < 0 {
+				problems = append(problems, fmt.Errorf("agency %q: level %q: negative min_dives", agency.ID, level.ID))
+			}
+		}
+		for _, level := range agency.Levels {
+			if level.Requires != "" && !levels[level.Requires] {
+				problems = append(problems, fmt.Errorf("agency %q: level %q requires unknown level %q", agency.ID, level.ID, level.Requires))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid training catalogue %s: %w", path, errors.Join(problems...))
+	}
+	return catalogue, nil
+}
+
+// reloadTrainingCatalogue checks the training catalogue file, and reloads it if it changed
+// since the last check. An invalid or missing file is replaced by the default catalogue.
+// Returns true if the catalogue in use changed.
+func reloadTrainingCatalogue() bool {
+	path := _control_block.trainingConfigPath
+	if path == "" {
+		return false
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		// report a missing file only once
+		if _training_catalogue_checked && _training_catalogue_modTime.IsZero() {
+			return false
+		}
+		_training_catalogue_checked = true
+		trace(_error, "using the default training catalogue: %v", err)
+		changed := _training_catalogue != _default_training_catalogue
+		_training_catalogue, _training_catalogue_modTime = _default_training_catalogue, time.Time{}
+		return changed
+	}
+	if _training_catalogue_checked && info.ModTime().Equal(_training_catalogue_modTime) {
+		return false
+	}
+	_training_catalogue_checked = true
+
+	// remember the modification time of invalid files too, to report them only once
+	_training_catalogue_modTime = info.ModTime()
+	catalogue, err := LoadTrainingCatalogue(path)
+	if err != nil {
+		trace(_error, "using the default training catalogue: %v", err)
+		changed := _training_catalogue != _default_training_catalogue
+		_training_catalogue = _default_training_catalogue
+		return changed
+	}
+
+	_training_catalogue = catalogue
+	trace(_build, "loaded training catalogue with %d agencies from %s", len(catalogue.Agencies), path)
+	return true
+}
+
+// isTrainingCatalogueFile reports whether the named file in the watch directory is the training catalogue.
+func isTrainingCatalogueFile(name string) bool {
+	path := _control_block.trainingConfigPath
+	return path != "" && filepath.Clean(path) == filepath.Join(_control_block.watchDirectoryPath, name)
+}
+
+func (tc *TrainingCatalogue) find(agencyID string, levelID string) (*Agency, *CertificationLevel) {
+	for _, agency := range tc.Agencies {
+		if agency.ID != agencyID {
+			continue
+		}
+		for _, level := range agency.Levels {
+			if level.ID == levelID {
+				return agency, level
+			}
+		}
+		return agency, nil
+	}
+	return nil, nil
+}
+
+// certificationTitle falls back to tag values for certifications not in the catalogue.
+func (tc *TrainingCatalogue) certificationTitle(agencyID string, levelID string) string {
+	agency, level := tc.find(agencyID, levelID)
+	agencyName, levelName := strings.ToUpper(agencyID), levelID
+	if agency != nil {
+		agencyName = agency.Name
+	}
+	if level != nil {
+		levelName = level.Name
+	}
+	return agencyName + " " + levelName
+}
+
+// NewTrainingRecord builds the certification timeline from cert and course tags
+// in chronological order, and lists certification levels which can be taken next.
+func NewTrainingRecord(divelog *DiveLog) *TrainingRecord {
+	catalogue := divelog.trainingCatalogue
+	record := &TrainingRecord{
+		LoggedDives:    divelog.LoggedDives(),
+		Certifications: []*Certification{},
+		Courses:        []*Course{},
+		NextLevels:     []*CertificationStep{},
+	}
+
+	var (
+		courseDives = make(map[string][]*DiveHead)
+		courseOrder []string
+		held        = make(map[certTag]bool)
+	)
+	dives := slices.Clone(divelog.Dives[1:])
+	sort.SliceStable(dives, func(i, j int) bool {
+		return dives[i].datetime.Before(dives[j].datetime)
+	})
+	for _, dive := range dives {
+		head := NewDiveHead(dive, divelog.DiveSites[dive.DiveSiteID])
+		for _, course := range dive.courses {
+			if _, ok := courseDives[course]; !ok {
+				courseOrder = append(courseOrder, course)
+			}
+			courseDives[course] = append(courseDives[course], head)
+		}
+
+		for _, cert := range dive.certs {
+			if held[cert] {
+				continue
+			}
+			held[cert] = true
+
+			course := cert.level
+			if _, level := catalogue.find(cert.agency, cert.level); level != nil && level.Course != "" {
+				course = level.Course
+			}
+			trainingDives := courseDives[course]
+			if trainingDives == nil {
+				trainingDives = []*DiveHead{}
+			}
+			record.Certifications = append(record.Certifications, &Certification{
+				Agency:         cert.agency,
+				Level:          cert.level,
+				Title:          catalogue.certificationTitle(cert.agency, cert.level),
+				Date:           dive.datetime.Format(time.DateOnly),
+				CertifyingDive: head,
+				TrainingDives:  trainingDives,
+			})
+			// the course is completed, training dives of a later course
+			// with the same name belong to the next certification
+			delete(courseDives, course)
+		}
+	}
+
+	for _, course := range courseOrder {
+		if dives, ok := courseDives[course]; ok {
+			record.Courses = append(record.Courses, &Course{
+				Name:          course,
+				TrainingDives: dives,
+			})
+			delete(courseDives, course)
+		}
+	}
+
+	for _, agency := range catalogue.Agencies {
+		for _, level := range agency.Levels {
+			if held[certTag{agency.ID, level.ID}] {
+				continue
+			}
+			if level.Requires == "" || !held[certTag{agency.ID, level.Requires}] {
+				continue
+			}
+			record.NextLevels = append(record.NextLevels, &CertificationStep{
+				Title:         agency.Name + " " + level.Name,
+				Prerequisite:  catalogue.certificationTitle(agency.ID, level.Requires),
+				DivesRequired: level.MinDives,
+				DivesLogged:   record.LoggedDives,
+				Eligible:      record.LoggedDives >= level.MinDives,
+			})
+		}
+	}
+
+	return record
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadTrainingCatalogue(t *testing.T) {
+	tests := []struct {
+		name     string
+		json     string
+		problems []string // substrings of the error, none if valid
+	}{
+		{
+			name: "valid",
+			json: `{"agencies": [{"id": "padi", "levels": [{"id": "owd"}, {"id": "aowd", "requires": "owd", "min_dives": 5}]}]}`,
+		},
+		{
+			name:     "duplicate agency",
+			json:     `{"agencies": [{"id": "padi"}, {"id": "padi"}]}`,
+			problems: []string{`agency 2: duplicate id "padi"`},
+		},
+		{
+			name:     "duplicate level",
+			json:     `{"agencies": [{"id": "padi", "levels": [{"id": "owd"}, {"id": "owd"}]}]}`,
+			problems: []string{`agency "padi": duplicate level id "owd"`},
+		},
+		{
+			name:     "unknown requires",
+			json:     `{"agencies": [{"id": "padi", "levels": [{"id": "aowd", "requires": "owd"}]}]}`,
+			problems: []string{`level "aowd" requires unknown level "owd"`},
+		},
+		{
+			name:     "requires a level of another agency",
+			json:     `{"agencies": [{"id": "ssi", "levels": [{"id": "owd"}]}, {"id": "padi", "levels": [{"id": "aowd", "requires": "owd"}]}]}`,
+			problems: []string{`agency "padi": level "aowd" requires unknown level "owd"`},
+		},
+		{
+			name: "requires a later level",
+			json: `{"agencies": [{"id": "padi", "levels": [{"id": "aowd", "requires": "owd"}, {"id": "owd"}]}]}`,
+		},
+		{
+			name: "all problems together",
+			json: `{"agencies": [{"id": "PADI", "levels": [{"id": "owd", "min_dives": -1}, {"id": "a_b", "requires": "x"}]}, {"id": "cmas_1"}]}`,
+			problems: []string{
+				`agency 1: id "PADI"`,
+				`level "owd": negative min_dives`,
+				`level id "a_b"`,
+				`requires unknown level "x"`,
+				`agency 2: id "cmas_1"`,
+			},
+		},
+		{
+			name:     "malformed",
+			json:     `{"agencies": {}}`,
+			problems: []string{"failed to parse training catalogue"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "training.json")
+			if err := os.WriteFile(path, []byte(tt.json), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			catalogue, err := LoadTrainingCatalogue(path)
+			if len(tt.problems) == 0 {
+				if err != nil {
+					t.Fatalf("LoadTrainingCatalogue: %v", err)
+				}
+				if catalogue == nil || len(catalogue.Agencies) == 0 {
+					t.Fatalf("LoadTrainingCatalogue = %v, want the agencies", catalogue)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("LoadTrainingCatalogue succeeded, want problems %q", tt.problems)
+			}
+			for _, problem := range tt.problems {
+				if !strings.Contains(err.Error(), problem) {
+					t.Errorf("error %q does not report %q", err, problem)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadTrainingCatalogueDefaults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "training.json")
+	if err := os.WriteFile(path, []byte(`{"agencies": [{"id": "padi", "levels": [{"id": "owd"}]}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	catalogue, err := LoadTrainingCatalogue(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := catalogue.certificationTitle("padi", "owd"); got != "PADI owd" {
+		t.Errorf("title = %q, want the agency ID in upper case and the level ID as names", got)
+	}
+	if got := catalogue.certificationTitle("ssi", "owd"); got != "SSI owd" {
+		t.Errorf("title of an unknown agency = %q, want %q", got, "SSI owd")
+	}
+}
+
+func TestReloadTrainingCatalogue(t *testing.T) {
+	watchDir, configPath := _control_block.watchDirectoryPath, _control_block.trainingConfigPath
+	t.Cleanup(func() {
+		_control_block.watchDirectoryPath, _control_block.trainingConfigPath = watchDir, configPath
+		_training_catalogue, _training_catalogue_modTime, _training_catalogue_checked = _default_training_catalogue, time.Time{}, false
+	})
+	dir := t.TempDir()
+	path := filepath.Join(dir, "training.json")
+	_control_block.watchDirectoryPath, _control_block.trainingConfigPath = dir, path
+
+	write := func(content string, modTime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	valid := `{"agencies": [{"id": "padi", "levels": [{"id": "owd"}]}]}`
+	modTime := time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+	steps := []struct {
+		name    string
+		update  func()
+		changed bool
+		custom  bool // the catalogue of the file is in use, not the default one
+	}{
+		{"missing", func() {}, false, false},
+		{"created", func() { write(valid, modTime) }, true, true},
+		{"unchanged", func() {}, false, true},
+		{"invalid", func() { write(`{"agencies": [{"id": ""}]}`, modTime.Add(time.Minute)) }, true, false},
+		{"still invalid", func() {}, false, false},
+		{"fixed", func() { write(valid, modTime.Add(2*time.Minute)) }, true, true},
+		{"removed", func() { os.Remove(path) }, true, false},
+	}
+	for _, step := range steps {
+		step.update()
+		if changed := reloadTrainingCatalogue(); changed != step.changed {
+			t.Errorf("%s: changed = %t, want %t", step.name, changed, step.changed)
+		}
+		if custom := _training_catalogue != _default_training_catalogue; custom != step.custom {
+			t.Errorf("%s: custom catalogue in use = %t, want %t", step.name, custom, step.custom)
+		}
+	}
+
+	if !isTrainingCatalogueFile("training.json") || isTrainingCatalogueFile("mappings.json") {
+		t.Errorf("isTrainingCatalogueFile does not match the catalogue in the watch directory")
+	}
+}
@@ -1,9 +1,12 @@
 package utils
 
 import (
+	"fmt"
+	"hash/fnv"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 )
 
 func ConvertAndCheckID(strid string, max int) int {
@@ -14,6 +17,70 @@ func ConvertAndCheckID(strid string, max int) int {
 	return id
 }
 
+// ShortHash returns a short, URL-safe hexadecimal digest of the given parts.
+// It is used to derive stable identifiers from source data, not for security.
+func ShortHash(parts ...string) string {
+	h := fnv.New32a()
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+// LongHash is ShortHash with a 64-bit digest, for identifiers derived
+// from many similar inputs, where 32 bits could collide.
+func LongHash(parts ...string) string {
+	h := fnv.New64a()
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// SplitNames splits a comma-separated list of names, as used by Subsurface
+// for buddies and dive masters, and normalizes whitespace in each name.
+// Names with the same slug are returned once, and so are repeated names without a slug.
+func SplitNames(list string) []string {
+	var (
+		names []string
+		seen  = make(map[string]bool)
+	)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.Join(strings.Fields(name), " ")
+		key := Slug(name)
+		if key == "" {
+			key = name
+		}
+		if name == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// Slug returns a lowercase, URL-friendly form of s, in which every run of
+// characters other than letters and digits is replaced by a single hyphen.
+func Slug(s string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			hyphen = false
+		} else {
+			hyphen = true
+		}
+	}
+	return b.String()
+}
+
 func IsSpecialTag(tag string) bool {
 	return strings.HasPrefix(tag, "_")
 }
@@ -66,3 +133,40 @@ func DurationToYMD(start time.Time, end time.Time) (years int, months int, days
 
 	return
 }
+
+// ParseDuration parses a Subsurface duration in the format "mm:ss min",
+// where the number of minutes is not limited to 59.
+// Returns 0 if the duration cannot be parsed.
+func ParseDuration(s string) time.Duration {
+	minutes, seconds, _ := strings.Cut(strings.TrimSuffix(strings.TrimSpace(s), " min"), ":")
+	m, err := strconv.Atoi(minutes)
+	if err != nil {
+		return 0
+	}
+	sec, err := strconv.Atoi(seconds)
+	if err != nil && seconds != "" {
+		return 0
+	}
+	return time.Duration(m)*time.Minute + time.Duration(sec)*time.Second
+}
+
+// ParseMeasurement parses a Subsurface measurement with a unit suffix,
+// e.g. "18.5 m" or "200.0 bar", and returns its numeric value.
+// Returns 0 if the measurement cannot be parsed.
+func ParseMeasurement(s string) float64 {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return 0
+	}
+	value, err := strconv.ParseFloat(strings.TrimSuffix(fields[0], "%"), 64)
+	if err != nil {
+		return 0
+	}
+	return value
+}
+
+// FormatHoursMinutes formats a duration in the format "12h 30m".
+func FormatHoursMinutes(d time.Duration) string {
+	d = d.Round(time.Minute)
+	return fmt.Sprintf("%dh %dm", int(d.Hours()), int(d.Minutes())%60)
+}
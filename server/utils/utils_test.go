@@ -0,0 +1,135 @@
+package utils
+
+import (
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestShortHash(t *testing.T) {
+	tests := []struct {
+		name  string
+		a, b  []string
+		equal bool
+	}{
+		{"same parts", []string{"Vis", "2023-06-01"}, []string{"Vis", "2023-06-01"}, true},
+		{"different parts", []string{"Vis", "2023-06-01"}, []string{"Vis", "2023-06-02"}, false},
+		{"parts are separated", []string{"ab", "c"}, []string{"a", "bc"}, false},
+		{"order matters", []string{"a", "b"}, []string{"b", "a"}, false},
+		{"no parts", nil, nil, true},
+	}
+	format := regexp.MustCompile(`^[0-9a-f]{8}$`)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := ShortHash(tt.a...), ShortHash(tt.b...)
+			if !format.MatchString(a) {
+				t.Errorf("ShortHash(%q) = %q, want 8 hexadecimal digits", tt.a, a)
+			}
+			if (a == b) != tt.equal {
+				t.Errorf("ShortHash(%q) = %q, ShortHash(%q) = %q, want equal %t", tt.a, a, tt.b, b, tt.equal)
+			}
+		})
+	}
+}
+
+func TestShortHashIsStable(t *testing.T) {
+	// stable IDs of existing permalinks depend on these values
+	tests := []struct {
+		parts []string
+		want  string
+	}{
+		{[]string{""}, "050c5d1f"},
+		{[]string{"Vis", "2023-06-01"}, "f13108e3"},
+	}
+	for _, tt := range tests {
+		if got := ShortHash(tt.parts...); got != tt.want {
+			t.Errorf("ShortHash(%q) = %q, want %q", tt.parts, got, tt.want)
+		}
+	}
+}
+
+func TestLongHash(t *testing.T) {
+	format := regexp.MustCompile(`^[0-9a-f]{16}$`)
+	tests := [][]string{{""}, {"trip", "Vis"}, {"0123abcd"}}
+	seen := make(map[string]bool)
+	for _, parts := range tests {
+		got := LongHash(parts...)
+		if !format.MatchString(got) {
+			t.Errorf("LongHash(%q) = %q, want 16 hexadecimal digits", parts, got)
+		}
+		if seen[got] {
+			t.Errorf("LongHash(%q) = %q collides", parts, got)
+		}
+		seen[got] = true
+		if again := LongHash(parts...); again != got {
+			t.Errorf("LongHash(%q) = %q, then %q", parts, got, again)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"45:30 min", 45*time.Minute + 30*time.Second},
+		{"45:00 min", 45 * time.Minute},
+		{"125:05 min", 125*time.Minute + 5*time.Second},
+		{" 7:09 min ", 7*time.Minute + 9*time.Second},
+		{"45 min", 45 * time.Minute},
+		{"45:30", 45*time.Minute + 30*time.Second},
+		{"0:00 min", 0},
+		{"", 0},
+		{"min", 0},
+		{"45:xx min", 0},
+		{"ab:30 min", 0},
+	}
+	for _, tt := range tests {
+		if got := ParseDuration(tt.in); got != tt.want {
+			t.Errorf("ParseDuration(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSlug(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"Ana Marić", "ana-marić"},
+		{"  Ana   Marić ", "ana-marić"},
+		{"O'Neil, Jr.", "o-neil-jr"},
+		{"Nitrox 32%", "nitrox-32"},
+		{"--a--b--", "a-b"},
+		{"Иван", "иван"},
+		{"", ""},
+		{"?!", ""},
+		{"🐟", ""},
+	}
+	for _, tt := range tests {
+		if got := Slug(tt.in); got != tt.want {
+			t.Errorf("Slug(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSplitNames(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"Ana, Ivo", []string{"Ana", "Ivo"}},
+		{"  Ana   Marić ,Ivo", []string{"Ana Marić", "Ivo"}},
+		{"Ana, ana, ANA", []string{"Ana"}},
+		{"Ana-Marija, Ana Marija", []string{"Ana-Marija"}},
+		{"Ana,, ,Ivo,", []string{"Ana", "Ivo"}},
+		{"?, !, ?", []string{"?", "!"}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := SplitNames(tt.in); !slices.Equal(got, tt.want) {
+			t.Errorf("SplitNames(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
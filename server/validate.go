@@ -0,0 +1,129 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"src.acicovic.me/divelog/subsurface"
+)
+
+// validate decodes the data file, optionally printing everything reported by the decoder
+// (formerly the sdv tool), and then builds the dive log the same way the server does.
+func validate(args []string) int {
+	fs := newCommandFlags("validate")
+	dump := fs.Bool("dump", false, "print the contents of the file as reported by the decoder")
+	strict := fs.Bool("strict", false, "fail if there are data problems")
+	if !parseCommandFlags(fs, args, 1) {
+		return exitUsage
+	}
+	path := fs.Arg(0)
+
+	if *dump {
+		file, err := os.Open(path)
+		if err != nil {
+			return fail(exitFailure, "failed to open file: %v", err)
+		}
+		defer file.Close()
+
+		if err := subsurface.DecodeSubsurfaceDatabase(file, dumpHandler{w: os.Stdout, fname: path}); err != nil {
+			return fail(exitInvalid, "decoding error: %v", err)
+		}
+	}
+
+	divelog, err := loadDiveLog(path)
+	if err != nil {
+		return failLoading(err)
+	}
+
+	for _, issue := range divelog.issues {
+		fmt.Printf("%s: %v\n", filepath.Base(path), issue)
+	}
+	if *strict && len(divelog.issues) > 0 {
+		return fail(exitInvalid, "%s: %d data problems", filepath.Base(path), len(divelog.issues))
+	}
+
+	fmt.Printf("%s: OK: %d dive sites, %d dive trips, %d dives, %d data problems\n",
+		filepath.Base(path), len(divelog.DiveSites)-1, len(divelog.DiveTrips)-1, len(divelog.Dives)-1, len(divelog.issues))
+	return exitOK
+}
+
+// dumpHandler prints everything reported by the decoder, for debugging data files.
+type dumpHandler struct {
+	w     io.Writer
+	fname string
+}
+
+func (h dumpHandler) HandleBegin() {
+	fmt.Fprintf(h.w, "SUBSURFACE_DATABASE %q\n", filepath.Base(h.fname))
+}
+
+func (h dumpHandler) HandleEnd() {
+	fmt.Fprintf(h.w, "END.\n")
+}
+
+func (h dumpHandler) HandleSkip(element string) {
+	fmt.Fprintf(h.w, ">>>>>\nSKIP ELEMENT %q\n<<<<<\n", element)
+}
+
+func (h dumpHandler) HandleHeader(program string, version string) {
+	fmt.Fprintf(h.w, "\tHEADER\n")
+	fmt.Fprintf(h.w, "\t\tPROGRAM = %q\n", program)
+	fmt.Fprintf(h.w, "\t\tVERSION = %q\n", version)
+}
+
+func (h dumpHandler) HandleDiveSite(uuid string, name string, coords string, description string) int {
+	fmt.Fprintf(h.w, "\tDIVE_SITE\n")
+	fmt.Fprintf(h.w, "\t\tUUID = %q\n\t\tNAME = %q\n\t\tCOORDS = %q\n\t\tDESCRIPTION = %q\n", uuid, name, coords, description)
+	return 0
+}
+
+func (h dumpHandler) HandleGeoData(id int, cat int, label string) {
+	fmt.Fprintf(h.w, "\t\tGEO_DATA\n")
+	fmt.Fprintf(h.w, "\t\t\tCATEGORY = %d\n\t\t\tLABEL = %q\n", cat, label)
+}
+
+func (h dumpHandler) HandleDiveTrip(label string) int {
+	fmt.Fprintf(h.w, "\tDIVE_TRIP %q\n", label)
+	return 0
+}
+
+func (h dumpHandler) HandleDive(ddh subsurface.DiveDataHolder) int {
+	fmt.Fprintf(h.w, "\t\tDIVE\n")
+	fmt.Fprintf(h.w, "\t\t\tNUMBER = %d\n", ddh.DiveNumber)
+	fmt.Fprintf(h.w, "\t\t\tRATING = %d\n", ddh.Rating)
+	fmt.Fprintf(h.w, "\t\t\tVISIBILITY = %d\n", ddh.Visibility)
+	fmt.Fprintf(h.w, "\t\t\tSAC = %q\n", ddh.SAC)
+	if len(ddh.Tags) > 0 {
+		fmt.Fprintf(h.w, "\t\t\tTAGS\n")
+		for _, tag := range ddh.Tags {
+			fmt.Fprintf(h.w, "\t\t\t\t%q\n", tag)
+		}
+	}
+	fmt.Fprintf(h.w, "\t\t\tWATER_SALINITY = %q\n", ddh.WaterSalinity)
+	fmt.Fprintf(h.w, "\t\t\tDATE_TIME = %s\n", ddh.DateTime.Format(time.RFC1123Z))
+	fmt.Fprintf(h.w, "\t\t\tDURATION = %q\n", ddh.Duration)
+	fmt.Fprintf(h.w, "\t\t\tDIVE_OPERATOR = %q\n", ddh.DiveMasterOrOperator)
+	fmt.Fprintf(h.w, "\t\t\tBUDDY = %q\n", ddh.Buddy)
+	fmt.Fprintf(h.w, "\t\t\tNOTES = %q\n", ddh.Notes)
+	fmt.Fprintf(h.w, "\t\t\tSUIT = %q\n", ddh.Suit)
+	fmt.Fprintf(h.w, "\t\t\tCYL_SIZE = %q\n", ddh.CylinderSize)
+	fmt.Fprintf(h.w, "\t\t\tCYL_WP = %q\n", ddh.CylinderWorkPressure)
+	fmt.Fprintf(h.w, "\t\t\tCYL_DESC = %q\n", ddh.CylinderDescription)
+	fmt.Fprintf(h.w, "\t\t\tCYL_START = %q\n", ddh.CylinderStartPressure)
+	fmt.Fprintf(h.w, "\t\t\tCYL_END = %q\n", ddh.CylinderEndPressure)
+	fmt.Fprintf(h.w, "\t\t\tCYL_GAS = %q\n", ddh.CylinderGas)
+	fmt.Fprintf(h.w, "\t\t\tWEIGHT = %q\n", ddh.Weight)
+	fmt.Fprintf(h.w, "\t\t\tWEIGHT_TYPE = %q\n", ddh.WeightType)
+	fmt.Fprintf(h.w, "\t\t\tDC_MODEL = %q\n", ddh.DiveComputerModel)
+	fmt.Fprintf(h.w, "\t\t\tDC_DEVICE_ID = %q\n", ddh.DiveComputerDeviceID)
+	fmt.Fprintf(h.w, "\t\t\tDC_DIVE_ID = %q\n", ddh.DiveComputerDiveID)
+	fmt.Fprintf(h.w, "\t\t\tDEPTH_MAX = %q\n", ddh.DepthMax)
+	fmt.Fprintf(h.w, "\t\t\tDEPTH_MEAN = %q\n", ddh.DepthMean)
+	fmt.Fprintf(h.w, "\t\t\tTEMP_WATER_MIN = %q\n", ddh.TemperatureWaterMin)
+	fmt.Fprintf(h.w, "\t\t\tTEMP_AIR = %q\n", ddh.TemperatureAir)
+	fmt.Fprintf(h.w, "\t\t\tSURFACE_PRESSURE = %q\n", ddh.SurfacePressure)
+	return 0
+}
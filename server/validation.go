@@ -0,0 +1,165 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"src.acicovic.me/divelog/server/utils"
+)
+
+// Data problems in the source file do not fail the build. Affected records are degraded
+// (e.g. invalid coordinates are dropped, or a dive is linked to a placeholder site),
+// and each problem is reported as a validation issue.
+
+const (
+	IssueMissingSite         = "missing_site"
+	IssueMissingTrip         = "missing_trip"
+	IssueInvalidCoordinates  = "invalid_coordinates"
+	IssueInvalidPressures    = "invalid_pressures"
+	IssueDuplicateDiveNumber = "duplicate_dive_number"
+	IssueDiveNumberGap       = "dive_number_gap"
+	IssueFutureDate          = "future_date"
+
+	RecordDiveSite = "site"
+	RecordDive     = "dive"
+
+	UnknownDiveSiteName = "Unknown dive site"
+	UnknownDiveTripName = "Unknown dive trip"
+
+	// not a valid Subsurface UUID, which is hexadecimal
+	placeholderSiteSourceID = "unknown"
+)
+
+// Dive times are recorded in local time, without a time zone.
+const futureDateTolerance = 24 * time.Hour
+
+type ValidationIssue struct {
+	Kind     string `json:"kind"`
+	Record   string `json:"record"`
+	ID       int    `json:"id,omitempty"`
+	StableID string `json:"stable_id,omitempty"`
+	Message  string `json:"message"`
+}
+
+func (i *ValidationIssue) String() string {
+	if i.StableID == "" {
+		return fmt.Sprintf("%s: %s", i.Kind, i.Message)
+	}
+	return fmt.Sprintf("%s %s: %s: %s", i.Record, i.StableID, i.Kind, i.Message)
+}
+
+type ValidationReport struct {
+	Source string             `json:"source"`
+	Counts map[string]int     `json:"counts"`
+	Issues []*ValidationIssue `json:"issues"`
+}
+
+func NewValidationReport(divelog *DiveLog) *ValidationReport {
+	report := &ValidationReport{
+		Source: divelog.Metadata.Source,
+		Counts: make(map[string]int),
+		Issues: []*ValidationIssue{},
+	}
+	for _, issue := range divelog.issues {
+		report.Counts[issue.Kind]++
+		report.Issues = append(report.Issues, issue)
+	}
+	return report
+}
+
+func reportIssue(kind string, record string, id int, stableID string, format string, args ...any) {
+	issue := &ValidationIssue{
+		Kind:     kind,
+		Record:   record,
+		ID:       id,
+		StableID: stableID,
+		Message:  fmt.Sprintf(format, args...),
+	}
+	trace(_build, "validation: %v", issue)
+	_divelog.issues = append(_divelog.issues, issue)
+}
+
+// validCoordinates reports whether the coordinates are in the "{lat} {long}" format, or empty.
+func validCoordinates(coords string) bool {
+	fields := strings.Fields(coords)
+	if len(fields) == 0 {
+		return true
+	}
+	if len(fields) != 2 {
+		return false
+	}
+	lat, latErr := strconv.ParseFloat(fields[0], 64)
+	long, longErr := strconv.ParseFloat(fields[1], 64)
+	return latErr == nil && longErr == nil && lat >= -90 && lat <= 90 && long >= -180 && long <= 180
+}
+
+// validatePressures drops cylinder pressures if the end pressure is above the start pressure.
+func validatePressures(dive *Dive) {
+	if dive.StartPressure == "" || dive.EndPressure == "" {
+		return
+	}
+	start, end := utils.ParseMeasurement(dive.StartPressure), utils.ParseMeasurement(dive.EndPressure)
+	if end <= start {
+		return
+	}
+	reportIssue(IssueInvalidPressures, RecordDive, dive.ID, dive.StableID,
+		"end pressure %s is above start pressure %s, pressures dropped", dive.EndPressure, dive.StartPressure)
+	dive.StartPressure, dive.EndPressure = "", ""
+}
+
+// placeholderSiteID returns the ID of the site for dives with unknown sites, adding it on first use.
+func (p *SubsurfaceCallbackHandler) placeholderSiteID() int {
+	if p.placeholderSite == 0 {
+		p.placeholderSite = p.HandleDiveSite(placeholderSiteSourceID, UnknownDiveSiteName, "", "")
+	}
+	return p.placeholderSite
+}
+
+// placeholderTripID returns the ID of the trip for dives with unknown trips, adding it on first use.
+func (p *SubsurfaceCallbackHandler) placeholderTripID() int {
+	if p.placeholderTrip == 0 {
+		p.placeholderTrip = p.HandleDiveTrip(UnknownDiveTripName)
+	}
+	return p.placeholderTrip
+}
+
+// validateDives checks the dive log as a whole, once all dives are known.
+func validateDives(divelog *DiveLog, now time.Time) {
+	var (
+		numbered = make(map[int][]*Dive)
+		numbers  []int
+	)
+	for _, dive := range divelog.Dives[1:] {
+		if dive.datetime.After(now.Add(futureDateTolerance)) {
+			reportIssue(IssueFutureDate, RecordDive, dive.ID, dive.StableID,
+				"dive date %s is in the future", dive.datetime.Format(time.DateOnly))
+		}
+		if dive.Number <= 0 {
+			continue
+		}
+		if len(numbered[dive.Number]) == 0 {
+			numbers = append(numbers, dive.Number)
+		}
+		numbered[dive.Number] = append(numbered[dive.Number], dive)
+	}
+
+	sort.Ints(numbers)
+	for i, number := range numbers {
+		if dives := numbered[number]; len(dives) > 1 {
+			for _, dive := range dives[1:] {
+				reportIssue(IssueDuplicateDiveNumber, RecordDive, dive.ID, dive.StableID,
+					"dive number %d is also used by dive %s", number, dives[0].StableID)
+			}
+		}
+		if i > 0 && number > numbers[i-1]+1 {
+			if missing := number - numbers[i-1] - 1; missing == 1 {
+				reportIssue(IssueDiveNumberGap, RecordDive, 0, "", "dive number %d is missing", numbers[i-1]+1)
+			} else {
+				reportIssue(IssueDiveNumberGap, RecordDive, 0, "", "dive numbers %d to %d are missing", numbers[i-1]+1, number-1)
+			}
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestValidateDives(t *testing.T) {
+	now := time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC)
+	past := now.AddDate(0, -1, 0)
+
+	tests := []struct {
+		name string
+		// dive numbers, all dives in the past
+		numbers []int
+		// dive times, all dives numbered from 1
+		times []time.Time
+		want  []string // kind and message of each issue
+	}{
+		{
+			name:    "consecutive",
+			numbers: []int{1, 2, 3},
+		},
+		{
+			name:    "out of order",
+			numbers: []int{3, 1, 2},
+		},
+		{
+			name:    "unnumbered",
+			numbers: []int{0, 1, 0, 2},
+		},
+		{
+			name:    "duplicate",
+			numbers: []int{1, 2, 2, 2},
+			want: []string{
+				IssueDuplicateDiveNumber + ": dive number 2 is also used by dive d2",
+				IssueDuplicateDiveNumber + ": dive number 2 is also used by dive d2",
+			},
+		},
+		{
+			name:    "gaps",
+			numbers: []int{1, 3, 7},
+			want: []string{
+				IssueDiveNumberGap + ": dive number 2 is missing",
+				IssueDiveNumberGap + ": dive numbers 4 to 6 are missing",
+			},
+		},
+		{
+			name:    "log starting later",
+			numbers: []int{100, 101},
+		},
+		{
+			name:  "today is not in the future",
+			times: []time.Time{now.Add(23 * time.Hour)},
+		},
+		{
+			name:  "future",
+			times: []time.Time{past, now.AddDate(0, 0, 2)},
+			want:  []string{IssueFutureDate + ": dive date 2024-06-03 is in the future"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			divelog := &DiveLog{Dives: []*Dive{nil}}
+			for _, number := range tt.numbers {
+				divelog.Dives = append(divelog.Dives, &Dive{Number: number, datetime: past})
+			}
+			for i, datetime := range tt.times {
+				divelog.Dives = append(divelog.Dives, &Dive{Number: i + 1, datetime: datetime})
+			}
+			for id, dive := range divelog.Dives[1:] {
+				dive.ID, dive.StableID = id+1, "d"+strconv.Itoa(id+1)
+			}
+
+			// issues are reported on the dive log being built
+			previous := _divelog
+			_divelog = divelog
+			defer func() { _divelog = previous }()
+			validateDives(divelog, now)
+
+			var got []string
+			for _, issue := range divelog.issues {
+				got = append(got, issue.Kind+": "+issue.Message)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("issues = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
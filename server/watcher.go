@@ -0,0 +1,90 @@
+package server
+
+import (
+	"strings"
+	"time"
+)
+
+// Changes in the watch directory are reported by the operating system where supported
+// (inotify on Linux). Otherwise, or if the directory cannot be watched, the directory
+// is checked every rebuild interval.
+
+// A burst of changes, e.g. a data file being copied in chunks, triggers one rebuild
+// once no changes were reported for this long.
+const watchDebounceWindow = 2 * time.Second
+
+// watchDataFiles returns a channel which receives a value whenever the builder should check
+// the watch directory again. Values are never queued, so pending wakeups are coalesced.
+func watchDataFiles() <-chan struct{} {
+	wakeups := make(chan struct{}, 1)
+
+	if !_control_block.inotify {
+		trace(_build, "polling %s every %s", _control_block.watchDirectoryPath, _control_block.rebuildInterval)
+		go pollDataFiles(wakeups)
+		return wakeups
+	}
+
+	names, err := watchDirectory(_control_block.watchDirectoryPath)
+	if err != nil {
+		trace(_error, "failed to watch %s, polling every %s instead: %v", _control_block.watchDirectoryPath, _control_block.rebuildInterval, err)
+		go pollDataFiles(wakeups)
+		return wakeups
+	}
+
+	trace(_build, "watching %s for changes", _control_block.watchDirectoryPath)
+	go debounceChanges(names, wakeups)
+	return wakeups
+}
+
+func pollDataFiles(wakeups chan<- struct{}) {
+	for range time.Tick(_control_block.rebuildInterval) {
+		wakeup(wakeups)
+	}
+}
+
+// debounceChanges forwards relevant changes reported by the watcher once they settle down,
+// and falls back to polling if the watcher stops.
+func debounceChanges(names <-chan string, wakeups chan<- struct{}) {
+	var (
+		settled = time.NewTimer(watchDebounceWindow)
+		pending bool
+	)
+	settled.Stop()
+
+	for {
+		select {
+		case name, ok := <-names:
+			if !ok {
+				trace(_error, "stopped watching %s, polling every %s instead", _control_block.watchDirectoryPath, _control_block.rebuildInterval)
+				if pending {
+					wakeup(wakeups)
+				}
+				pollDataFiles(wakeups)
+				return
+			}
+			if !isWatchedFile(name) {
+				continue
+			}
+			trace(_build, "change of %q reported in the watch directory", name)
+			pending = true
+			settled.Reset(watchDebounceWindow)
+		case <-settled.C:
+			pending = false
+			wakeup(wakeups)
+		}
+	}
+}
+
+// isWatchedFile reports whether a change of the named file can affect the build.
+// An empty name stands for changes which could not be attributed to a file.
+func isWatchedFile(name string) bool {
+	return name == "" || name == MappingsFileName || strings.HasPrefix(name, _control_block.dataFilePrefix) ||
+		isGearConfigFile(name) || isTrainingCatalogueFile(name)
+}
+
+func wakeup(wakeups chan<- struct{}) {
+	select {
+	case wakeups <- struct{}{}:
+	default:
+	}
+}
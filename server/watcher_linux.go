@@ -0,0 +1,62 @@
+package server
+
+import (
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_MOVED_FROM |
+	syscall.IN_DELETE | syscall.IN_ATTRIB
+
+// watchDirectory reports names of files changed in the directory, until the watch is
+// removed (e.g. the directory is deleted) or reading events fails, when the channel is closed.
+func watchDirectory(path string) (<-chan string, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	if _, err := syscall.InotifyAddWatch(fd, path, inotifyMask); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("inotify_add_watch", err)
+	}
+
+	names := make(chan string)
+	go readInotifyEvents(fd, names)
+	return names, nil
+}
+
+func readInotifyEvents(fd int, names chan<- string) {
+	defer close(names)
+	defer syscall.Close(fd)
+
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := syscall.Read(fd, buf)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil || n < syscall.SizeofInotifyEvent {
+			trace(_error, "inotify: failed to read events: %v", err)
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			start := offset + syscall.SizeofInotifyEvent
+			offset = start + int(event.Len)
+
+			switch {
+			case event.Mask&syscall.IN_IGNORED != 0:
+				trace(_error, "inotify: watch removed")
+				return
+			case event.Mask&syscall.IN_Q_OVERFLOW != 0:
+				// events were lost, so any file could have changed
+				names <- ""
+			default:
+				names <- strings.TrimRight(string(buf[start:offset]), "\x00")
+			}
+		}
+	}
+}
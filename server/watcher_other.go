@@ -0,0 +1,9 @@
+//go:build !linux
+
+package server
+
+import "errors"
+
+func watchDirectory(path string) (<-chan string, error) {
+	return nil, errors.New("watching directories is supported only on Linux")
+}
@@ -0,0 +1,199 @@
+package subsurface
+
+import (
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Recorder is a Handler which keeps everything reported by the decoder,
+// so that the database can be encoded again in the same format.
+// Elements skipped by the decoder (e.g. settings and dive profiles) are not kept.
+type Recorder struct {
+	Program string
+	Version string
+	Sites   []*SiteXML
+	Trips   []*TripXML
+}
+
+type TripXML struct {
+	Location string
+	Dives    []*DiveXML
+}
+
+func (r *Recorder) HandleBegin() {}
+
+func (r *Recorder) HandleEnd() {}
+
+func (r *Recorder) HandleSkip(element string) {}
+
+func (r *Recorder) HandleHeader(program string, version string) {
+	r.Program, r.Version = program, version
+}
+
+func (r *Recorder) HandleDiveSite(uuid string, name string, coords string, description string) int {
+	r.Sites = append(r.Sites, &SiteXML{
+		UUID:        uuid,
+		Name:        name,
+		GPS:         coords,
+		Description: description,
+	})
+	return len(r.Sites)
+}
+
+func (r *Recorder) HandleGeoData(siteID int, cat int, label string) {
+	site := r.Sites[siteID-1]
+	site.Geos = append(site.Geos, GeoXML{Cat: strconv.Itoa(cat), Value: label})
+}
+
+func (r *Recorder) HandleDiveTrip(label string) int {
+	r.Trips = append(r.Trips, &TripXML{Location: label})
+	return len(r.Trips)
+}
+
+func (r *Recorder) HandleDive(ddh DiveDataHolder) int {
+	trip := r.Trips[ddh.DiveTripID-1]
+	trip.Dives = append(trip.Dives, UnflattenDive(ddh))
+	return len(trip.Dives)
+}
+
+// UnflattenDive is the inverse of the flattening done by FlattenAndReport.
+func UnflattenDive(ddh DiveDataHolder) *DiveXML {
+	diveXML := &DiveXML{
+		SAC:           ddh.SAC,
+		Tags:          strings.Join(ddh.Tags, ", "),
+		DiveSiteUUID:  ddh.DiveSiteUUID,
+		WaterSalinity: ddh.WaterSalinity,
+		Duration:      ddh.Duration,
+		DiveMaster:    ddh.DiveMasterOrOperator,
+		Buddy:         ddh.Buddy,
+		Notes:         ddh.Notes,
+		Suit:          ddh.Suit,
+		Cylinder: CylinderXML{
+			Size:         ddh.CylinderSize,
+			WorkPressure: ddh.CylinderWorkPressure,
+			Description:  ddh.CylinderDescription,
+			Start:        ddh.CylinderStartPressure,
+			End:          ddh.CylinderEndPressure,
+			O2:           ddh.CylinderGas,
+		},
+		WeightSystem: WeightSystemXML{
+			Weight:      ddh.Weight,
+			Description: ddh.WeightType,
+		},
+		TemperatureManual: TemperatureManualXML{
+			Air: ddh.TemperatureAir,
+		},
+		DiveComputer: DiveComputerXML{
+			Model:    ddh.DiveComputerModel,
+			DeviceID: ddh.DiveComputerDeviceID,
+			DiveID:   ddh.DiveComputerDiveID,
+			DepthInfo: DepthInfoXML{
+				Max:  ddh.DepthMax,
+				Mean: ddh.DepthMean,
+			},
+			TemperatureInfo: TemperatureInfoXML{
+				WaterMin: ddh.TemperatureWaterMin,
+			},
+			SurfaceInfo: SurfaceInfoXML{
+				Pressure: ddh.SurfacePressure,
+			},
+		},
+	}
+
+	if ddh.DiveNumber != IntNull {
+		diveXML.Number = strconv.Itoa(ddh.DiveNumber)
+	}
+	if ddh.Rating != IntNull {
+		diveXML.Rating = strconv.Itoa(ddh.Rating)
+	}
+	if ddh.Visibility != IntNull {
+		diveXML.Visibility = strconv.Itoa(ddh.Visibility)
+	}
+	if IsValidDateTime(ddh.DateTime) {
+		diveXML.Date = ddh.DateTime.Format(time.DateOnly)
+		diveXML.Time = ddh.DateTime.Format(time.TimeOnly)
+	}
+
+	return diveXML
+}
+
+// Encode writes the recorded database in the format expected by DecodeSubsurfaceDatabase.
+func (r *Recorder) Encode(w io.Writer) error {
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+
+	divelog := xml.StartElement{
+		Name: xml.Name{Local: "divelog"},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "program"}, Value: r.Program},
+			{Name: xml.Name{Local: "version"}, Value: r.Version},
+		},
+	}
+	if err := encoder.EncodeToken(divelog); err != nil {
+		return err
+	}
+	if err := encodeEmptyElement(encoder, "settings"); err != nil {
+		return err
+	}
+
+	divesites := xml.StartElement{Name: xml.Name{Local: "divesites"}}
+	if err := encoder.EncodeToken(divesites); err != nil {
+		return err
+	}
+	for _, site := range r.Sites {
+		if err := encoder.Encode(site); err != nil {
+			return err
+		}
+	}
+	if err := encoder.EncodeToken(divesites.End()); err != nil {
+		return err
+	}
+
+	dives := xml.StartElement{Name: xml.Name{Local: "dives"}}
+	if err := encoder.EncodeToken(dives); err != nil {
+		return err
+	}
+	for _, trip := range r.Trips {
+		start := xml.StartElement{
+			Name: xml.Name{Local: "trip"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "location"}, Value: trip.Location}},
+		}
+		// the trip starts with its first dive
+		if len(trip.Dives) > 0 && trip.Dives[0].Date != "" {
+			start.Attr = append(start.Attr,
+				xml.Attr{Name: xml.Name{Local: "date"}, Value: trip.Dives[0].Date},
+				xml.Attr{Name: xml.Name{Local: "time"}, Value: trip.Dives[0].Time},
+			)
+		}
+		if err := encoder.EncodeToken(start); err != nil {
+			return err
+		}
+		for _, dive := range trip.Dives {
+			if err := encoder.EncodeElement(dive, xml.StartElement{Name: xml.Name{Local: "dive"}}); err != nil {
+				return err
+			}
+		}
+		if err := encoder.EncodeToken(start.End()); err != nil {
+			return err
+		}
+	}
+	if err := encoder.EncodeToken(dives.End()); err != nil {
+		return err
+	}
+
+	if err := encoder.EncodeToken(divelog.End()); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+func encodeEmptyElement(encoder *xml.Encoder, tag string) error {
+	start := xml.StartElement{Name: xml.Name{Local: tag}}
+	if err := encoder.EncodeToken(start); err != nil {
+		return err
+	}
+	return encoder.EncodeToken(start.End())
+}
@@ -6,33 +6,33 @@ import (
 
 type SiteXML struct {
 	XMLName     xml.Name `xml:"site"`
-	UUID        string   `xml:"uuid,attr"`
-	Name        string   `xml:"name,attr"`
-	GPS         string   `xml:"gps,attr"`
-	Description string   `xml:"description,attr"`
+	UUID        string   `xml:"uuid,attr,omitempty"`
+	Name        string   `xml:"name,attr,omitempty"`
+	GPS         string   `xml:"gps,attr,omitempty"`
+	Description string   `xml:"description,attr,omitempty"`
 	Geos        []GeoXML `xml:"geo"`
 }
 
 type GeoXML struct {
-	Cat   string `xml:"cat,attr"`
-	Value string `xml:"value,attr"`
+	Cat   string `xml:"cat,attr,omitempty"`
+	Value string `xml:"value,attr,omitempty"`
 }
 
 type DiveXML struct {
-	Number            string               `xml:"number,attr"`
-	Rating            string               `xml:"rating,attr"`
-	Visibility        string               `xml:"visibility,attr"`
-	SAC               string               `xml:"sac,attr"`
-	Tags              string               `xml:"tags,attr"`
-	DiveSiteUUID      string               `xml:"divesiteid,attr"`
-	WaterSalinity     string               `xml:"watersalinity,attr"`
-	Date              string               `xml:"date,attr"`
-	Time              string               `xml:"time,attr"`
-	Duration          string               `xml:"duration,attr"`
-	DiveMaster        string               `xml:"divemaster"`
-	Buddy             string               `xml:"buddy"`
-	Notes             string               `xml:"notes"`
-	Suit              string               `xml:"suit"`
+	Number            string               `xml:"number,attr,omitempty"`
+	Rating            string               `xml:"rating,attr,omitempty"`
+	Visibility        string               `xml:"visibility,attr,omitempty"`
+	SAC               string               `xml:"sac,attr,omitempty"`
+	Tags              string               `xml:"tags,attr,omitempty"`
+	DiveSiteUUID      string               `xml:"divesiteid,attr,omitempty"`
+	WaterSalinity     string               `xml:"watersalinity,attr,omitempty"`
+	Date              string               `xml:"date,attr,omitempty"`
+	Time              string               `xml:"time,attr,omitempty"`
+	Duration          string               `xml:"duration,attr,omitempty"`
+	DiveMaster        string               `xml:"divemaster,omitempty"`
+	Buddy             string               `xml:"buddy,omitempty"`
+	Notes             string               `xml:"notes,omitempty"`
+	Suit              string               `xml:"suit,omitempty"`
 	Cylinder          CylinderXML          `xml:"cylinder"`
 	WeightSystem      WeightSystemXML      `xml:"weightsystem"`
 	TemperatureManual TemperatureManualXML `xml:"divetemperature"`
@@ -40,42 +40,42 @@ type DiveXML struct {
 }
 
 type CylinderXML struct {
-	Size         string `xml:"size,attr"`
-	WorkPressure string `xml:"workpressure,attr"`
-	Description  string `xml:"description,attr"`
-	Start        string `xml:"start,attr"`
-	End          string `xml:"end,attr"`
-	O2           string `xml:"o2,attr"`
+	Size         string `xml:"size,attr,omitempty"`
+	WorkPressure string `xml:"workpressure,attr,omitempty"`
+	Description  string `xml:"description,attr,omitempty"`
+	Start        string `xml:"start,attr,omitempty"`
+	End          string `xml:"end,attr,omitempty"`
+	O2           string `xml:"o2,attr,omitempty"`
 }
 
 type WeightSystemXML struct {
-	Weight      string `xml:"weight,attr"`
-	Description string `xml:"description,attr"`
+	Weight      string `xml:"weight,attr,omitempty"`
+	Description string `xml:"description,attr,omitempty"`
 }
 
 type TemperatureManualXML struct {
-	Air   string `xml:"air,attr"`
-	Water string `xml:"water,attr"`
+	Air   string `xml:"air,attr,omitempty"`
+	Water string `xml:"water,attr,omitempty"`
 }
 
 type DiveComputerXML struct {
-	Model           string             `xml:"model,attr"`
-	DeviceID        string             `xml:"deviceid,attr"`
-	DiveID          string             `xml:"diveid,attr"`
+	Model           string             `xml:"model,attr,omitempty"`
+	DeviceID        string             `xml:"deviceid,attr,omitempty"`
+	DiveID          string             `xml:"diveid,attr,omitempty"`
 	DepthInfo       DepthInfoXML       `xml:"depth"`
 	TemperatureInfo TemperatureInfoXML `xml:"temperature"`
 	SurfaceInfo     SurfaceInfoXML     `xml:"surface"`
 }
 
 type DepthInfoXML struct {
-	Max  string `xml:"max,attr"`
-	Mean string `xml:"mean,attr"`
+	Max  string `xml:"max,attr,omitempty"`
+	Mean string `xml:"mean,attr,omitempty"`
 }
 
 type TemperatureInfoXML struct {
-	WaterMin string `xml:"water,attr"`
+	WaterMin string `xml:"water,attr,omitempty"`
 }
 
 type SurfaceInfoXML struct {
-	Pressure string `xml:"pressure,attr"`
+	Pressure string `xml:"pressure,attr,omitempty"`
 }